@@ -1,11 +1,13 @@
 package main
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -37,28 +39,69 @@ type AppWriter struct {
 	translator   Translator
 	forceSendPLI func()
 
-	retransmit chan uint16
-	rtx        bool
+	nack *nackScheduler
+	rtx  bool
+	// Payload type of retransmissions sent in the same stream as the media (RFC 4588), 0 if not negotiated
+	rtxPayloadType webrtc.PayloadType
 
 	nullSamples  int
 	validSamples int
 
 	// state
-	state     state
-	ticker    *time.Ticker
-	muted     atomic.Bool
-	draining  core.Fuse
-	endStream core.Fuse
-	finished  core.Fuse
+	state      state
+	ticker     *time.Ticker
+	muted      atomic.Bool
+	stalled    atomic.Bool
+	lastPacket time.Time
+	draining   core.Fuse
+	endStream  core.Fuse
+	finished   core.Fuse
 
 	// rate limiter for PLI
 	lastPLI time.Time
 
+	stats trackStats
+
 	//Checker for gaps in the stream
 	gapLock  sync.RWMutex
 	dropping bool
 }
 
+// GST_RTP_BUFFER_FLAG_RETRANSMISSION, as set by rtprtxreceive, keeps retransmitted packets out of the
+// jitter and clock skew estimation of the jitterbuffer
+const rtpBufferFlagRetransmission = gst.BufferFlagLast
+
+// Payload type used for retransmissions of the given media payload type, from the "apt" parameter of the
+// negotiated RTX codecs
+func rtxPayloadTypeFor(codecs []webrtc.RTPCodecParameters, payloadType webrtc.PayloadType) webrtc.PayloadType {
+	apt := fmt.Sprintf("apt=%d", payloadType)
+	for _, codec := range codecs {
+		if !strings.HasSuffix(strings.ToLower(codec.MimeType), "/rtx") {
+			continue
+		}
+		for _, param := range strings.Split(codec.SDPFmtpLine, ";") {
+			if strings.TrimSpace(param) == apt {
+				return codec.PayloadType
+			}
+		}
+	}
+	return 0
+}
+
+// Restores a retransmission received on the RTX payload type the way rtprtxreceive does: the original
+// sequence number is carried in the first two bytes of the payload
+func decapsulateRTX(pkt *rtp.Packet, payloadType webrtc.PayloadType) error {
+	if len(pkt.Payload) < 2 {
+		// Padding only packets are bandwidth probes
+		return errors.New("RTX packet without original sequence number")
+	}
+	pkt.SequenceNumber = binary.BigEndian.Uint16(pkt.Payload[:2])
+	pkt.Payload = pkt.Payload[2:]
+	pkt.PayloadType = uint8(payloadType)
+
+	return nil
+}
+
 type GapStatus int
 
 const (
@@ -67,38 +110,6 @@ const (
 	EndGap
 )
 
-func (w *AppWriter) AccumulateNumSeqs(blocking bool) ([]uint16, bool) {
-	var seqnums []uint16
-
-	seqnums = make([]uint16, 20)
-	i := 0
-	// Block till one message appear
-	if blocking {
-		i++
-		seqnum, ok := <-w.retransmit
-		if !ok {
-			return nil, false
-		}
-		seqnums = append(seqnums, seqnum)
-	}
-
-	// Then accumulate non blocking
-	for i < 20 {
-		i++
-		select {
-		case seqnum, ok := <-w.retransmit:
-			if !ok {
-				return nil, false
-			}
-			seqnums = append(seqnums, seqnum)
-		default:
-			return seqnums, true
-		}
-
-	}
-	return seqnums, true
-}
-
 func (w *AppWriter) SendNack(seqs []uint16) {
 	var packets []rtcp.Packet
 
@@ -114,24 +125,24 @@ func (w *AppWriter) SendNack(seqs []uint16) {
 }
 
 func (w *AppWriter) RetransmissionsTask() {
-	var seqnums []uint16
+	timer := time.NewTimer(nackMaxRetry)
+	defer timer.Stop()
 
 	for !w.endStream.IsBroken() {
-		seq, ok := w.AccumulateNumSeqs(true)
-		if !ok {
-			return
-		}
-		seqnums = append(seqnums, seq...)
-		// We just wait to accumulate burst
-		time.Sleep(2 * time.Millisecond)
-		seq, ok = w.AccumulateNumSeqs(false)
-		if !ok {
+		select {
+		case <-w.endStream.Watch():
 			return
+		case <-w.nack.wake:
+			timer.Reset(nackBatchDelay)
+			continue
+		case <-timer.C:
 		}
-		seqnums = append(seqnums, seq...)
 
-		w.SendNack(seqnums)
-		seqnums = []uint16{}
+		seqnums, next := w.nack.due(time.Now(), w.subscription.retransmissionDeadline(w.kind))
+		if len(seqnums) > 0 {
+			w.SendNack(seqnums)
+		}
+		timer.Reset(next)
 	}
 }
 
@@ -139,12 +150,12 @@ func (w *AppWriter) retransmitPacket(seqnum uint) {
 	root.logger.Debugw(fmt.Sprintf("retransmitPacket: Need retrasmission of packet %d in track %s", seqnum, w.pub.SID()))
 
 	if w.rtx {
-		select {
-		case w.retransmit <- uint16(seqnum):
+		if w.nack.request(w.subscription.continuity(w.kind).originalSN(w, uint16(seqnum)), time.Now()) {
+			w.stats.nackRequests.Add(1)
 			return
-		default:
-			root.logger.Debugw(fmt.Sprintf("retransmitPacket: Cannot request retrasmission of packet %d in track %s", seqnum, w.pub.SID()))
 		}
+		w.stats.nackDropped.Add(1)
+		root.logger.Debugw(fmt.Sprintf("retransmitPacket: Cannot request retrasmission of packet %d in track %s", seqnum, w.pub.SID()))
 	}
 }
 
@@ -204,6 +215,15 @@ func (w *AppWriter) CheckStillInGap(buffer []byte) GapStatus {
 func (w *AppWriter) PushRTCPPacket(pkt rtcp.Packet) {
 	var appSrc *app.Source
 
+	if sr, ok := pkt.(*rtcp.SenderReport); ok {
+		munged, ok := w.subscription.continuity(w.kind).mungeSR(w, sr)
+		if !ok {
+			root.logger.Debugw(fmt.Sprintf("PushRTCPPacket: sender report before first packet of track %s, ignoring", w.pub.SID()))
+			return
+		}
+		pkt = munged
+	}
+
 	p, err := pkt.Marshal()
 	if (err != nil) || (len(p) == 0) {
 		w.logger.Errorw("PushRTCPPacket: could not marshal RTCP packet", err)
@@ -211,6 +231,10 @@ func (w *AppWriter) PushRTCPPacket(pkt rtcp.Packet) {
 		return
 	}
 
+	w.stats.rtcpPackets.Add(1)
+	if sr, ok := pkt.(*rtcp.SenderReport); ok {
+		w.subscription.lipSync.updateSR(w.kind, sr, w.ClockRate)
+	}
 	b := gst.NewBufferFromBytes(p)
 	w.subscription.Lock()
 	subscribers := w.subscription.subscribers
@@ -258,8 +282,40 @@ func (w *AppWriter) TrackID() string {
 	return w.track.ID()
 }
 
+// Mute and stall state of the track as seen by the subscribers
+func (w *AppWriter) mediaState() mediaState {
+	return mediaState{Muted: w.muted.Load(), Stalled: w.stalled.Load()}
+}
+
+// Lets the subscriber bins know the track is muted or stalled, as opposed to broken
+func (w *AppWriter) signalState() {
+	state := w.mediaState()
+
+	w.subscription.RLock()
+	subscribers := w.subscription.subscribers
+	w.subscription.RUnlock()
+	for _, subscriber := range subscribers {
+		if subscriber != nil {
+			subscriber.setMediaState(w.kind, state)
+		}
+	}
+}
+
+func (w *AppWriter) setStalled(stalled bool) {
+	if w.stalled.Swap(stalled) == stalled {
+		return
+	}
+	if stalled {
+		root.logger.Debugw(fmt.Sprintf("setStalled: no packets for %s on track %s", time.Since(w.lastPacket), w.pub.SID()))
+	} else {
+		root.logger.Debugw(fmt.Sprintf("setStalled: packets resumed on track %s", w.pub.SID()))
+	}
+	w.signalState()
+}
+
 func (w *AppWriter) SetTrackMuted(muted bool) {
 	w.muted.Store(muted)
+	defer w.signalState()
 	if muted {
 		w.logger.Debugw("track muted", "timestamp", time.Since(w.startTime).Seconds())
 		root.logger.Debugw(fmt.Sprintf("SetTrackMuted: track muted %s", w.pub.SID()))
@@ -272,9 +328,9 @@ func (w *AppWriter) SetTrackMuted(muted bool) {
 
 // Send PLI with a rate limiter applied
 func (w *AppWriter) sendPLI() {
-	// rate limit PLIs to at most one each 2 seconds
+	// rate limit PLIs to at most one each configured interval
 	now := time.Now()
-	if now.Sub(w.lastPLI) > 1*time.Second {
+	if now.Sub(w.lastPLI) > getConfig().pliInterval() {
 		if w.forceSendPLI != nil {
 			w.forceSendPLI()
 		}
@@ -291,7 +347,7 @@ func (w *AppWriter) Drain(force bool) {
 			w.endStream.Break()
 		} else {
 			// wait until drainTimeout before force popping
-			time.AfterFunc(drainTimeout, w.endStream.Break)
+			time.AfterFunc(getConfig().drainTimeout(), w.endStream.Break)
 		}
 	})
 }
@@ -305,6 +361,7 @@ func (w *AppWriter) run() {
 		root.logger.Debugw(fmt.Sprintf("run: writer for track %s terminated", w.pub.SID()))
 	}()
 	w.startTime = time.Now()
+	w.lastPacket = w.startTime
 
 	// We wait till first keyframe
 	w.EnterInGap()
@@ -340,7 +397,7 @@ func (w *AppWriter) handlePlaying() {
 	//root.logger.Printf("handlePlaying: track %s", w.pub.SID())
 	// read next packet
 	_ = w.track.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
-	pkt, _, err := w.track.ReadRTP()
+	pkt, attributes, err := w.track.ReadRTP()
 	if err != nil {
 		w.handleReadError(err)
 		w.sendPLI()
@@ -354,8 +411,22 @@ func (w *AppWriter) handlePlaying() {
 		return
 	}
 
+	// Packets from a separate RTX stream are already restored by the receiver, those sent in the media
+	// stream with the RTX payload type are restored here
+	retransmission := attributes.Get(webrtc.AttributeRtxSsrc) != nil
+	if !retransmission && (w.rtxPayloadType != 0) && (pkt.PayloadType == uint8(w.rtxPayloadType)) {
+		if err = decapsulateRTX(pkt, w.PayloadType); err != nil {
+			return
+		}
+		retransmission = true
+	}
+	if retransmission {
+		w.stats.rtxPackets.Add(1)
+	}
+	w.nack.received(pkt.SequenceNumber, time.Now())
+
 	// push completed packets to appsrc
-	if err = w.pushSamples(pkt); err != nil {
+	if err = w.pushSamples(pkt, retransmission); err != nil {
 		root.logger.Debugw(fmt.Sprintf("handlePlaying: push samples error %s %s", w.pub.SID(), err.Error()))
 		w.draining.Once(w.endStream.Break)
 	}
@@ -404,6 +475,9 @@ func (w *AppWriter) handleReadError(err error) {
 	// continue on timeout
 	var netErr net.Error
 	if errors.As(err, &netErr) && netErr.Timeout() {
+		if stall := getConfig().subscriberStall(); (stall > 0) && (time.Since(w.lastPacket) > stall) {
+			w.setStalled(true)
+		}
 		return
 	}
 
@@ -429,19 +503,23 @@ func isKeyFrameStart(pkt *rtp.Packet, codec types.MimeType) bool {
 	return false
 }
 
-func (w *AppWriter) pushSamples(pkt *rtp.Packet) error {
+func (w *AppWriter) pushSamples(pkt *rtp.Packet, retransmission bool) error {
 	var err error
 
 	// No gap detection here, now it is done on rtpjitterbuffer
 	if w.state == stateUnmuting {
 		w.state = statePlaying
 	}
+	w.lastPacket = time.Now()
+	if w.stalled.Load() {
+		w.setStalled(false)
+	}
 
 	if w.logFile != nil {
 		_, _ = w.logFile.WriteString(fmt.Sprintf("%s: (%d) %d,%d\n", time.Now(), pkt.SSRC, pkt.SequenceNumber, pkt.Timestamp))
 	}
 
-	if err = w.pushPacket(pkt); err != nil {
+	if err = w.pushPacket(pkt, retransmission); err != nil {
 		root.logger.Infow(fmt.Sprintf("pushSamples: ERROR pushing packets, %s", w.pub.SID()))
 		return err
 	}
@@ -449,17 +527,23 @@ func (w *AppWriter) pushSamples(pkt *rtp.Packet) error {
 	return nil
 }
 
-func (w *AppWriter) pushPacket(pkt *rtp.Packet) error {
+func (w *AppWriter) pushPacket(pkt *rtp.Packet, retransmission bool) error {
 	var appSrc *app.Source
 
+	w.subscription.continuity(w.kind).munge(w, pkt)
 	p, err := pkt.Marshal()
 	if err != nil {
 		w.logger.Errorw("could not marshal packet", err)
 		root.logger.Debugw(fmt.Sprintf("pushPacket: could not marshal packet %s", w.pub.SID()))
 		return err
 	}
+	w.stats.packets.Add(1)
+	w.stats.bytes.Add(uint64(len(p)))
 
 	b := gst.NewBufferFromBytes(p)
+	if retransmission {
+		b.SetFlags(rtpBufferFlagRetransmission)
+	}
 	w.subscription.Lock()
 	subscribers := w.subscription.subscribers
 	w.subscription.Unlock()
@@ -490,6 +574,7 @@ func (w *AppWriter) pushPacket(pkt *rtp.Packet) error {
 			if appSrc != nil {
 				flow := appSrc.PushBuffer(b)
 				if flow != gst.FlowOK {
+					w.stats.pushFlowErrors.Add(1)
 					w.logger.Infow("unexpected flow return", "flow", flow)
 					root.logger.Infow(fmt.Sprintf("pushPacket: unexpected flow return %s", w.pub.SID()))
 				}
@@ -12,8 +12,10 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/livekit/egress v1.8.6
 	github.com/livekit/livekit-server v1.8.1-0.20241129023712-3372e6e28532
+	github.com/livekit/mediatransportutil v0.0.0-20241128072814-c363618d4c98
 	github.com/livekit/protocol v1.29.5-0.20241210172052-933b7ff01414
 	github.com/livekit/server-sdk-go/v2 v2.4.0
+	github.com/pion/interceptor v0.1.37
 	github.com/pion/rtcp v1.2.14
 	github.com/pion/rtp v1.8.9
 	github.com/pion/webrtc/v4 v4.0.4
@@ -42,7 +44,6 @@ require (
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/lithammer/shortuuid/v4 v4.0.0 // indirect
 	github.com/livekit/mageutil v0.0.0-20230125210925-54e8a70427c1 // indirect
-	github.com/livekit/mediatransportutil v0.0.0-20241128072814-c363618d4c98 // indirect
 	github.com/livekit/psrpc v0.6.1-0.20241018124827-1efff3d113a8 // indirect
 	github.com/magefile/mage v1.15.0 // indirect
 	github.com/mattn/go-pointer v0.0.1 // indirect
@@ -52,7 +53,6 @@ require (
 	github.com/pion/datachannel v1.5.9 // indirect
 	github.com/pion/dtls/v3 v3.0.4 // indirect
 	github.com/pion/ice/v4 v4.0.3 // indirect
-	github.com/pion/interceptor v0.1.37 // indirect
 	github.com/pion/logging v0.2.2 // indirect
 	github.com/pion/mdns/v2 v2.0.7 // indirect
 	github.com/pion/randutil v0.1.0 // indirect
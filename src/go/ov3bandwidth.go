@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-gst/go-gst/gst"
+	lkinterceptor "github.com/livekit/mediatransportutil/pkg/interceptor"
+	sdkinterceptor "github.com/livekit/server-sdk-go/v2/pkg/interceptor"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/interceptor/pkg/report"
+	"github.com/pion/interceptor/pkg/twcc"
+)
+
+// Custom upstream event sent from the publisher sink bin with the bitrate, in bps, the encoder feeding it
+// should target
+const bitrateEvent = "ov3-bitrate"
+
+const (
+	bweInitialBitrate = 1000000
+	bweMinBitrate     = 100000
+	bweMaxBitrate     = 10000000
+	// Kept out of the estimate for each published audio track, audio is not adapted
+	bweAudioReserve = 64000
+	// Smaller changes are not signalled, encoders do not need to follow every estimate
+	bitrateChangeThreshold = 10
+)
+
+// Interceptors of the publisher connection of an ingress. Setting them replaces the SDK defaults, so these
+// are kept, and transport-wide sequence numbers are added so the SFU sends TWCC feedback and the
+// congestion controller can estimate the available bandwidth.
+func (ing *ov3Ingress) publisherInterceptors() ([]interceptor.Factory, error) {
+	generator := &sdkinterceptor.NackGeneratorInterceptorFactory{}
+	responder, err := nack.NewResponderInterceptor()
+	if err != nil {
+		return nil, err
+	}
+	senderReports, err := report.NewSenderInterceptor()
+	if err != nil {
+		return nil, err
+	}
+	receiverReports, err := report.NewReceiverInterceptor()
+	if err != nil {
+		return nil, err
+	}
+	twccGenerator, err := twcc.NewSenderInterceptor()
+	if err != nil {
+		return nil, err
+	}
+	twccExtension, err := twcc.NewHeaderExtensionInterceptor()
+	if err != nil {
+		return nil, err
+	}
+	// Media is not paced, only the estimate is used
+	congestionControl, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(bweInitialBitrate),
+			gcc.SendSideBWEMinBitrate(bweMinBitrate),
+			gcc.SendSideBWEMaxBitrate(bweMaxBitrate),
+			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	congestionControl.OnNewPeerConnection(func(id string, estimator cc.BandwidthEstimator) {
+		root.logger.Debugw(fmt.Sprintf("publisherInterceptors: bandwidth estimation started for ingress %s", ing.ingressId))
+		estimator.OnTargetBitrateChange(ing.setEstimatedBitrate)
+	})
+
+	return []interceptor.Factory{
+		generator,
+		responder,
+		senderReports,
+		receiverReports,
+		twccGenerator,
+		twccExtension,
+		congestionControl,
+		sdkinterceptor.NewLimitSizeInterceptorFactory(),
+		// RTT from receiver reports paces the NACKs we generate, the SDK does the same with its defaults
+		sdkinterceptor.NewRTTInterceptorFactory(generator.SetRTT),
+		// The SFU measures its RTT to us with XR, the answer is all that is needed on a publisher
+		lkinterceptor.NewRTTFromXRFactory(func(rtt uint32) {}),
+	}, nil
+}
+
+// The estimate covers everything the ingress publishes, video tracks share what audio leaves
+func (ing *ov3Ingress) setEstimatedBitrate(bitrate int) {
+	var video []*ov3TrackPublisher
+	available := int64(bitrate)
+
+	ing.RLock()
+	for _, pub := range []*ov3Publisher{ing.mainPub, ing.screenSharePub} {
+		if pub == nil {
+			continue
+		}
+		if pub.audioPublisher != nil {
+			available -= bweAudioReserve
+		}
+		if pub.videoPublisher != nil {
+			video = append(video, pub.videoPublisher)
+		}
+	}
+	ing.RUnlock()
+
+	if len(video) == 0 {
+		return
+	}
+	share := available / int64(len(video))
+	if share < bweMinBitrate {
+		share = bweMinBitrate
+	}
+	for _, trPub := range video {
+		trPub.setTargetBitrate(uint64(share), "twcc")
+	}
+}
+
+// Asks the encoder feeding the publisher for a new bitrate. Estimates are applied as they come, the SFU
+// only sends REMB when TWCC is not in use
+func (tr *ov3TrackPublisher) setTargetBitrate(bitrate uint64, source string) {
+	previous := tr.targetBitrate.Load()
+	if (previous != 0) && (bitrate*100 > previous*(100-bitrateChangeThreshold)) &&
+		(bitrate*100 < previous*(100+bitrateChangeThreshold)) {
+		return
+	}
+	tr.targetBitrate.Store(bitrate)
+
+	tr.RLock()
+	sinkPad := tr.sinkPad
+	sid := tr.sid
+	tr.RUnlock()
+
+	root.logger.Debugw(fmt.Sprintf("setTargetBitrate: %s track of publisher %s to %d bps from %s", tr.kind, tr.publisher.id, bitrate, source))
+	if sinkPad != nil {
+		structure := gst.NewStructure(bitrateEvent)
+		structure.SetValue("bitrate", uint(bitrate))
+		structure.SetValue("source", source)
+		if !sinkPad.PushEvent(gst.NewCustomEvent(gst.EventTypeCustomUpstream, structure)) {
+			root.logger.Debugw(fmt.Sprintf("setTargetBitrate: event not handled upstream of publisher %s", tr.publisher.id))
+		}
+	}
+	emitEvent(tr.publisher.id, "PublisherBitrate", map[string]interface{}{
+		"ingressId": tr.publisher.ingress.ingressId,
+		"kind":      string(tr.kind),
+		"sid":       sid,
+		"bitrate":   bitrate,
+		"source":    source,
+	})
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/go-gst/go-gst/gst"
+	guuid "github.com/google/uuid"
+	"github.com/livekit/protocol/livekit"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+const (
+	layoutGrid         = "grid"
+	layoutSpeaker      = "speaker"
+	layoutPresentation = "presentation"
+)
+
+var compositeLayouts = map[string]bool{
+	layoutGrid:         true,
+	layoutSpeaker:      true,
+	layoutPresentation: true,
+}
+
+// compositor "background" enum nicks
+var compositeBackgrounds = map[string]bool{
+	"checker":     true,
+	"black":       true,
+	"white":       true,
+	"transparent": true,
+}
+
+// Thumbnails shown next to the main input on speaker and presentation layouts, as a fraction of the output
+const (
+	maxThumbnails  = 5
+	thumbnailRatio = 5
+)
+
+// Options given by Kurento when creating a video composite
+type compositeOptions struct {
+	Layout       string   `json:"layout"`
+	Width        int      `json:"width"`
+	Height       int      `json:"height"`
+	Framerate    int      `json:"framerate"`
+	ScreenShare  bool     `json:"screenShare"`
+	Background   string   `json:"background"`
+	Participants []string `json:"participants"`
+}
+
+func defaultCompositeOptions() compositeOptions {
+	return compositeOptions{
+		Layout:      layoutGrid,
+		Width:       1280,
+		Height:      720,
+		Framerate:   30,
+		ScreenShare: true,
+		Background:  "black",
+	}
+}
+
+func (opts *compositeOptions) validate() error {
+	if !compositeLayouts[opts.Layout] {
+		return fmt.Errorf("invalid composite layout %s", opts.Layout)
+	}
+	if (opts.Width < 16) || (opts.Width > 7680) || (opts.Height < 16) || (opts.Height > 4320) {
+		return fmt.Errorf("invalid composite size %dx%d", opts.Width, opts.Height)
+	}
+	if !compositeBackgrounds[opts.Background] {
+		return fmt.Errorf("invalid composite background %s", opts.Background)
+	}
+	if (opts.Framerate < 1) || (opts.Framerate > 60) {
+		return fmt.Errorf("composite framerate %d must be between 1 and 60", opts.Framerate)
+	}
+	for _, participant := range opts.Participants {
+		if participant == "" {
+			return errors.New("empty participant in composite")
+		}
+	}
+	return nil
+}
+
+func parseCompositeOptions(options string) (compositeOptions, error) {
+	result := defaultCompositeOptions()
+
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &result); err != nil {
+			return result, err
+		}
+	}
+	if err := result.validate(); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Composes the camera and screen share tracks of the participants of a room into a single video bin.
+// Each track is an ordinary subscriber whose video bin is decoded and placed on a compositor according
+// to the selected layout, which follows the active speaker.
+type ov3VideoComposite struct {
+	sync.RWMutex
+	roomParticipants
+	id         string
+	bin        *gst.Bin
+	compositor *gst.Element
+	options    compositeOptions
+	inputs     map[string]*compositeInput
+	speaker    string
+	nextInput  int
+}
+
+// Elements feeding one participant track into the compositor
+type compositeInput struct {
+	*roomInput
+	key    string
+	active bool
+	muted  bool // muted or stalled video keeps its place on the layout but is not drawn
+}
+
+type layoutInput struct {
+	key         string
+	participant string
+	screenShare bool
+}
+
+type layoutRect struct {
+	X, Y          int
+	Width, Height int
+	ZOrder        uint
+	Visible       bool
+}
+
+func compositeKey(participant string, screenShare bool) string {
+	if screenShare {
+		return participant + "#screen"
+	}
+	return participant
+}
+
+// Places the inputs on a width x height canvas. Inputs not in the result are hidden.
+func computeLayout(layout string, width int, height int, inputs []layoutInput, speaker string) map[string]layoutRect {
+	result := make(map[string]layoutRect)
+	if len(inputs) == 0 {
+		return result
+	}
+	sort.Slice(inputs, func(i, j int) bool { return inputs[i].key < inputs[j].key })
+
+	var main *layoutInput
+	switch layout {
+	case layoutPresentation:
+		for i := range inputs {
+			if inputs[i].screenShare && ((main == nil) || (inputs[i].participant == speaker)) {
+				main = &inputs[i]
+			}
+		}
+		if main == nil {
+			return computeLayout(layoutSpeaker, width, height, inputs, speaker)
+		}
+	case layoutSpeaker:
+		for i := range inputs {
+			if !inputs[i].screenShare && (inputs[i].participant == speaker) {
+				main = &inputs[i]
+			}
+		}
+		if main == nil {
+			main = &inputs[0]
+		}
+	default:
+		cols := int(math.Ceil(math.Sqrt(float64(len(inputs)))))
+		rows := (len(inputs) + cols - 1) / cols
+		cellWidth := width / cols
+		cellHeight := height / rows
+		for i, input := range inputs {
+			result[input.key] = layoutRect{
+				X:       (i % cols) * cellWidth,
+				Y:       (i / cols) * cellHeight,
+				Width:   cellWidth,
+				Height:  cellHeight,
+				Visible: true,
+			}
+		}
+		return result
+	}
+
+	thumbWidth := width / thumbnailRatio
+	thumbHeight := height / thumbnailRatio
+	thumbs := make([]layoutInput, 0, len(inputs))
+	for _, input := range inputs {
+		if input.key == main.key {
+			continue
+		}
+		// Speaker first among thumbnails so it is never left out
+		if input.participant == speaker {
+			thumbs = append([]layoutInput{input}, thumbs...)
+		} else {
+			thumbs = append(thumbs, input)
+		}
+	}
+	if len(thumbs) > maxThumbnails {
+		thumbs = thumbs[:maxThumbnails]
+	}
+
+	if layout == layoutPresentation {
+		// Presentation on the left, cameras stacked on a right column
+		result[main.key] = layoutRect{Width: width - thumbWidth, Height: height, Visible: true}
+		for i, input := range thumbs {
+			result[input.key] = layoutRect{
+				X:       width - thumbWidth,
+				Y:       i * thumbHeight,
+				Width:   thumbWidth,
+				Height:  thumbHeight,
+				Visible: true,
+			}
+		}
+	} else {
+		// Speaker full frame, the rest on a strip over its bottom
+		result[main.key] = layoutRect{Width: width, Height: height, Visible: true}
+		for i, input := range thumbs {
+			result[input.key] = layoutRect{
+				X:       i * thumbWidth,
+				Y:       height - thumbHeight,
+				Width:   thumbWidth,
+				Height:  thumbHeight,
+				ZOrder:  1,
+				Visible: true,
+			}
+		}
+	}
+
+	return result
+}
+
+func newVideoComposite(room *ov3Room, bin *gst.Bin, options compositeOptions) (*ov3VideoComposite, error) {
+	composite := &ov3VideoComposite{
+		roomParticipants: newRoomParticipants(room, options.Participants),
+		id:               "GSTCP_" + guuid.New().String(),
+		bin:              bin,
+		options:          options,
+		inputs:           make(map[string]*compositeInput),
+	}
+
+	// Names carry the composite id, several composites may share the bin
+	compositor, err := gst.NewElementWithName("compositor", composite.id+"_compositor")
+	if err != nil {
+		return nil, err
+	}
+	SetArg(compositor.Object, "background", options.Background)
+	// Inputs are live, output starts with the first buffer instead of at running time 0
+	SetArg(compositor.Object, "start-time-selection", "first")
+	convert, err := gst.NewElementWithName("videoconvert", composite.id+"_convert")
+	if err != nil {
+		return nil, err
+	}
+	capsFilter, err := gst.NewElementWithName("capsfilter", composite.id+"_caps")
+	if err != nil {
+		return nil, err
+	}
+	capsFilter.SetProperty("caps", gst.NewCapsFromString(fmt.Sprintf("video/x-raw,width=%d,height=%d,framerate=%d/1",
+		options.Width, options.Height, options.Framerate)))
+	if err = bin.AddMany(compositor, convert, capsFilter); err != nil {
+		return nil, err
+	}
+	if err = gst.ElementLinkMany(compositor, convert, capsFilter); err != nil {
+		return nil, err
+	}
+	compositor.SyncStateWithParent()
+	convert.SyncStateWithParent()
+	capsFilter.SyncStateWithParent()
+	if err = exposeSrcInBin(capsFilter, bin); err != nil {
+		return nil, err
+	}
+	composite.compositor = compositor
+
+	return composite, nil
+}
+
+// This must be called with composite lock held
+func (composite *ov3VideoComposite) createInput(participant string, screenShare bool) (*compositeInput, error) {
+	index := composite.nextInput
+	composite.nextInput++
+	result := &compositeInput{key: compositeKey(participant, screenShare)}
+	input, err := newRoomInput(composite.id, index, composite.bin, composite.compositor, participant, screenShare, []string{"videoconvert"}, func() {
+		// Out of the streaming thread, the layout takes the composite lock
+		go composite.activate(result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.roomInput = input
+	SetArg(input.aggregatorPad.Object, "sizing-policy", "keep-aspect-ratio")
+	input.aggregatorPad.SetProperty("alpha", 0.0)
+
+	return result, nil
+}
+
+// This must be called with composite lock held
+func (composite *ov3VideoComposite) applyLayout() {
+	inputs := make([]layoutInput, 0, len(composite.inputs))
+	for _, input := range composite.inputs {
+		if input.active {
+			inputs = append(inputs, layoutInput{input.key, input.participant, input.screenShare})
+		}
+	}
+	rects := computeLayout(composite.options.Layout, composite.options.Width, composite.options.Height, inputs, composite.speaker)
+
+	for key, input := range composite.inputs {
+		pad := input.aggregatorPad
+		rect, ok := rects[key]
+		if !ok || !rect.Visible || input.muted {
+			pad.SetProperty("alpha", 0.0)
+			continue
+		}
+		pad.SetProperty("xpos", rect.X)
+		pad.SetProperty("ypos", rect.Y)
+		pad.SetProperty("width", rect.Width)
+		pad.SetProperty("height", rect.Height)
+		pad.SetProperty("zorder", rect.ZOrder)
+		pad.SetProperty("alpha", 1.0)
+	}
+}
+
+func (composite *ov3VideoComposite) activate(input *compositeInput) {
+	composite.Lock()
+	defer composite.Unlock()
+
+	if composite.inputs[input.key] != input {
+		return
+	}
+	input.active = true
+	root.logger.Debugw(fmt.Sprintf("activate: %s video shown on composite %s", input.key, composite.id))
+	composite.applyLayout()
+}
+
+// This must be called with composite lock held
+func (composite *ov3VideoComposite) addInput(participant string, screenShare bool) {
+	key := compositeKey(participant, screenShare)
+	if _, ok := composite.inputs[key]; ok {
+		return
+	}
+
+	input, err := composite.createInput(participant, screenShare)
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("addInput: cannot add %s to composite %s", key, composite.id), err)
+		return
+	}
+	input.subscribe(composite.room, lksdk.TrackKindVideo)
+	input.subscriber.Lock()
+	input.subscriber.onMediaState = func(kind lksdk.TrackKind, state mediaState) {
+		if kind == lksdk.TrackKindVideo {
+			// Out of the caller, the layout takes the composite lock
+			go composite.setMuted(input, !state.active())
+		}
+	}
+	input.muted = !input.subscriber.videoState.active()
+	input.subscriber.Unlock()
+	composite.inputs[key] = input
+}
+
+func (composite *ov3VideoComposite) setMuted(input *compositeInput, muted bool) {
+	composite.Lock()
+	defer composite.Unlock()
+
+	if (composite.inputs[input.key] != input) || (input.muted == muted) {
+		return
+	}
+	input.muted = muted
+	root.logger.Debugw(fmt.Sprintf("setMuted: %s video muted %t on composite %s", input.key, muted, composite.id))
+	composite.applyLayout()
+}
+
+// This must be called with composite lock held
+func (composite *ov3VideoComposite) removeInput(participant string, screenShare bool) bool {
+	key := compositeKey(participant, screenShare)
+	input := composite.inputs[key]
+	if input == nil {
+		return false
+	}
+	delete(composite.inputs, key)
+	input.destroy(composite.bin, composite.compositor)
+	return true
+}
+
+// Camera of the participant, its screen share is added once published
+func (composite *ov3VideoComposite) addParticipant(participant string) {
+	composite.Lock()
+	defer composite.Unlock()
+
+	if !composite.wants(participant) {
+		return
+	}
+	composite.addInput(participant, false)
+
+	root.logger.Debugw(fmt.Sprintf("addParticipant: participant %s added to composite %s", participant, composite.id))
+}
+
+func (composite *ov3VideoComposite) removeParticipant(participant string) {
+	composite.Lock()
+	defer composite.Unlock()
+
+	camera := composite.removeInput(participant, false)
+	screenShare := composite.removeInput(participant, true)
+	if camera || screenShare {
+		root.logger.Debugw(fmt.Sprintf("removeParticipant: participant %s removed from composite %s", participant, composite.id))
+		composite.applyLayout()
+	}
+}
+
+func (composite *ov3VideoComposite) addScreenShare(participant string) {
+	composite.Lock()
+	defer composite.Unlock()
+
+	if !composite.options.ScreenShare || !composite.wants(participant) {
+		return
+	}
+	composite.addInput(participant, true)
+
+	root.logger.Debugw(fmt.Sprintf("addScreenShare: participant %s screen share added to composite %s", participant, composite.id))
+}
+
+func (composite *ov3VideoComposite) removeScreenShare(participant string) {
+	composite.Lock()
+	defer composite.Unlock()
+
+	if composite.removeInput(participant, true) {
+		root.logger.Debugw(fmt.Sprintf("removeScreenShare: participant %s screen share removed from composite %s", participant, composite.id))
+		composite.applyLayout()
+	}
+}
+
+func (composite *ov3VideoComposite) setLayout(layout string) error {
+	if !compositeLayouts[layout] {
+		return fmt.Errorf("invalid composite layout %s", layout)
+	}
+
+	composite.Lock()
+	defer composite.Unlock()
+
+	composite.options.Layout = layout
+	composite.applyLayout()
+	return nil
+}
+
+// Speakers come sorted by audio level, the loudest one leads the layout. Silence keeps the last speaker.
+func (composite *ov3VideoComposite) setSpeakers(speakers []string) {
+	composite.Lock()
+	defer composite.Unlock()
+
+	for _, speaker := range speakers {
+		if _, ok := composite.inputs[compositeKey(speaker, false)]; !ok {
+			continue
+		}
+		if speaker != composite.speaker {
+			composite.speaker = speaker
+			if composite.options.Layout != layoutGrid {
+				composite.applyLayout()
+			}
+		}
+		return
+	}
+}
+
+// Composes the participants already in the room and their screen shares, later ones are added from room callbacks
+func (composite *ov3VideoComposite) start() {
+	for _, participant := range composite.publishing(livekit.TrackSource_UNKNOWN) {
+		composite.addParticipant(participant)
+	}
+	for _, participant := range composite.publishing(livekit.TrackSource_SCREEN_SHARE) {
+		composite.addScreenShare(participant)
+	}
+}
+
+func (composite *ov3VideoComposite) destroy() {
+	composite.Lock()
+	defer composite.Unlock()
+
+	for key, input := range composite.inputs {
+		input.destroy(composite.bin, composite.compositor)
+		delete(composite.inputs, key)
+	}
+}
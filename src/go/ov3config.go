@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultConfigFile = "/etc/kurento/modules/ov3endpoint/ov3endpoint.conf.json"
+
+// Tuning values of the library. Durations are expressed in milliseconds so the same names
+// can be used on the config file, on environment variables and on setConfig. Log rotation
+// uses megabytes and hours, a zero value disables that limit.
+type ov3Config struct {
+	LogsPath        string            `json:"logsPath"`
+	LogLevel        string            `json:"logLevel"`
+	LogLevels       map[string]string `json:"logLevels,omitempty"`
+	LogJSON         bool              `json:"logJson"`
+	LogMaxSize      uint              `json:"logMaxSize"`
+	LogMaxAge       uint              `json:"logMaxAge"`
+	LogMaxFiles     uint              `json:"logMaxFiles"`
+	PtsLog          bool              `json:"ptsLog"`
+	MetricsAddr     string            `json:"metricsAddr"`
+	MetricsPath     string            `json:"metricsPath"`
+	AudioLatency    uint              `json:"audioLatency"`
+	VideoLatency    uint              `json:"videoLatency"`
+	DrainTimeout    uint              `json:"drainTimeout"`
+	PLIInterval     uint              `json:"pliInterval"`
+	AppSrcMaxBytes  uint64            `json:"appSrcMaxBytes"`
+	RetransmitQueue uint              `json:"retransmitQueue"`
+	PublisherStall  uint              `json:"publisherStall"`
+	SubscriberStall uint              `json:"subscriberStall"`
+}
+
+var currentConfig atomic.Pointer[ov3Config]
+
+// Serializes updates, readers just load currentConfig
+var configLock sync.Mutex
+
+func defaultConfig() *ov3Config {
+	return &ov3Config{
+		LogsPath:        "/tmp",
+		LogLevel:        "debug",
+		LogJSON:         false,
+		LogMaxSize:      100,
+		LogMaxAge:       24,
+		LogMaxFiles:     10,
+		PtsLog:          false,
+		MetricsAddr:     "",
+		MetricsPath:     "/metrics",
+		AudioLatency:    200,
+		VideoLatency:    500,
+		DrainTimeout:    4000,
+		PLIInterval:     1000,
+		AppSrcMaxBytes:  2000000,
+		RetransmitQueue: 20,
+		PublisherStall:  3000,
+		SubscriberStall: 2000,
+	}
+}
+
+func getConfig() *ov3Config {
+	cfg := currentConfig.Load()
+	if cfg == nil {
+		return defaultConfig()
+	}
+	return cfg
+}
+
+// Copy safe to be modified, maps are not shared with the original
+func (cfg *ov3Config) clone() *ov3Config {
+	result := *cfg
+	if cfg.LogLevels != nil {
+		result.LogLevels = make(map[string]string, len(cfg.LogLevels))
+		for component, level := range cfg.LogLevels {
+			result.LogLevels[component] = level
+		}
+	}
+	return &result
+}
+
+func (cfg *ov3Config) drainTimeout() time.Duration {
+	return time.Duration(cfg.DrainTimeout) * time.Millisecond
+}
+
+func (cfg *ov3Config) pliInterval() time.Duration {
+	return time.Duration(cfg.PLIInterval) * time.Millisecond
+}
+
+// Zero disables stall detection of published tracks
+func (cfg *ov3Config) publisherStall() time.Duration {
+	return time.Duration(cfg.PublisherStall) * time.Millisecond
+}
+
+// Zero disables stall signalling on subscribed tracks
+func (cfg *ov3Config) subscriberStall() time.Duration {
+	return time.Duration(cfg.SubscriberStall) * time.Millisecond
+}
+
+func (cfg *ov3Config) validate() error {
+	if cfg.LogsPath == "" {
+		return errors.New("logsPath cannot be empty")
+	}
+	if _, ok := logLevels[cfg.LogLevel]; !ok {
+		return fmt.Errorf("invalid logLevel %s", cfg.LogLevel)
+	}
+	for component, level := range cfg.LogLevels {
+		if _, ok := logLevels[level]; !ok {
+			return fmt.Errorf("invalid logLevels value %s for %s", level, component)
+		}
+	}
+	if (cfg.MetricsAddr != "") && (cfg.MetricsPath == "") {
+		return errors.New("metricsPath cannot be empty when metricsAddr is set")
+	}
+	if cfg.AudioLatency > maxJitterBufferLatency {
+		return fmt.Errorf("audioLatency %d exceeds %d ms", cfg.AudioLatency, maxJitterBufferLatency)
+	}
+	if cfg.VideoLatency > maxJitterBufferLatency {
+		return fmt.Errorf("videoLatency %d exceeds %d ms", cfg.VideoLatency, maxJitterBufferLatency)
+	}
+	if (cfg.DrainTimeout == 0) || (cfg.DrainTimeout > 60000) {
+		return fmt.Errorf("drainTimeout %d must be between 1 and 60000 ms", cfg.DrainTimeout)
+	}
+	if (cfg.PLIInterval < 100) || (cfg.PLIInterval > 10000) {
+		return fmt.Errorf("pliInterval %d must be between 100 and 10000 ms", cfg.PLIInterval)
+	}
+	if cfg.AppSrcMaxBytes < 100000 {
+		return fmt.Errorf("appSrcMaxBytes %d must be at least 100000", cfg.AppSrcMaxBytes)
+	}
+	if (cfg.RetransmitQueue == 0) || (cfg.RetransmitQueue > 10000) {
+		return fmt.Errorf("retransmitQueue %d must be between 1 and 10000", cfg.RetransmitQueue)
+	}
+	if (cfg.PublisherStall != 0) && ((cfg.PublisherStall < 1000) || (cfg.PublisherStall > 60000)) {
+		return fmt.Errorf("publisherStall %d must be 0 or between 1000 and 60000 ms", cfg.PublisherStall)
+	}
+	if (cfg.SubscriberStall != 0) && ((cfg.SubscriberStall < 1000) || (cfg.SubscriberStall > 60000)) {
+		return fmt.Errorf("subscriberStall %d must be 0 or between 1000 and 60000 ms", cfg.SubscriberStall)
+	}
+	return nil
+}
+
+func lookupUintEnv(name string, value *uint) error {
+	str, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	v, err := strconv.ParseUint(str, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid value %s for %s", str, name)
+	}
+	*value = uint(v)
+	return nil
+}
+
+func (cfg *ov3Config) applyEnv() error {
+	var errs []error
+
+	if path, ok := os.LookupEnv("KURENTO_LOGS_PATH"); ok {
+		cfg.LogsPath = path
+	}
+	if level, ok := os.LookupEnv("KURENTO_LK_LOG_LEVEL"); ok {
+		cfg.LogLevel = level
+	}
+	// Comma separated component=level pairs, as in "ov3endpoint=debug,livekit=info"
+	if levels, ok := os.LookupEnv("KURENTO_LK_LOG_LEVELS"); ok {
+		cfg.LogLevels = make(map[string]string)
+		for _, pair := range strings.Split(levels, ",") {
+			component, level, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if !found {
+				errs = append(errs, fmt.Errorf("invalid value %s for KURENTO_LK_LOG_LEVELS", pair))
+				continue
+			}
+			cfg.LogLevels[component] = level
+		}
+	}
+	if str, ok := os.LookupEnv("KURENTO_LK_LOG_JSON"); ok {
+		v, err := strconv.ParseBool(str)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %s for KURENTO_LK_LOG_JSON", str))
+		} else {
+			cfg.LogJSON = v
+		}
+	}
+	errs = append(errs, lookupUintEnv("KURENTO_LK_LOG_MAX_SIZE", &cfg.LogMaxSize))
+	errs = append(errs, lookupUintEnv("KURENTO_LK_LOG_MAX_AGE", &cfg.LogMaxAge))
+	errs = append(errs, lookupUintEnv("KURENTO_LK_LOG_MAX_FILES", &cfg.LogMaxFiles))
+	// Just being defined enables the PTS log, as it always did
+	if _, ok := os.LookupEnv("KURENTO_LK_SUBSCRIBER_PTS_LOG"); ok {
+		cfg.PtsLog = true
+	}
+	if addr, ok := os.LookupEnv("KURENTO_LK_METRICS_ADDR"); ok {
+		cfg.MetricsAddr = addr
+	}
+	if path, ok := os.LookupEnv("KURENTO_LK_METRICS_PATH"); ok {
+		cfg.MetricsPath = path
+	}
+	errs = append(errs, lookupUintEnv("KURENTO_LK_AUDIO_LATENCY", &cfg.AudioLatency))
+	errs = append(errs, lookupUintEnv("KURENTO_LK_VIDEO_LATENCY", &cfg.VideoLatency))
+	errs = append(errs, lookupUintEnv("KURENTO_LK_DRAIN_TIMEOUT", &cfg.DrainTimeout))
+	errs = append(errs, lookupUintEnv("KURENTO_LK_PLI_INTERVAL", &cfg.PLIInterval))
+	errs = append(errs, lookupUintEnv("KURENTO_LK_RETRANSMIT_QUEUE", &cfg.RetransmitQueue))
+	errs = append(errs, lookupUintEnv("KURENTO_LK_PUBLISHER_STALL", &cfg.PublisherStall))
+	errs = append(errs, lookupUintEnv("KURENTO_LK_SUBSCRIBER_STALL", &cfg.SubscriberStall))
+	if str, ok := os.LookupEnv("KURENTO_LK_APPSRC_MAX_BYTES"); ok {
+		v, err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %s for KURENTO_LK_APPSRC_MAX_BYTES", str))
+		} else {
+			cfg.AppSrcMaxBytes = v
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Defaults, then config file, then environment. Any invalid source is skipped and reported back
+// as the logger is not available yet when this runs.
+func loadConfig() error {
+	var errs []error
+
+	cfg := defaultConfig()
+
+	filename, ok := os.LookupEnv("KURENTO_LK_CONFIG_FILE")
+	if !ok {
+		filename = defaultConfigFile
+	}
+	data, err := os.ReadFile(filename)
+	if err == nil {
+		fileCfg := cfg.clone()
+		if err = json.Unmarshal(data, fileCfg); err != nil {
+			errs = append(errs, fmt.Errorf("config file %s ignored: %w", filename, err))
+		} else if err = fileCfg.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("config file %s ignored: %w", filename, err))
+		} else {
+			cfg = fileCfg
+		}
+	} else if ok || !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, fmt.Errorf("config file %s not readable: %w", filename, err))
+	}
+
+	envCfg := cfg.clone()
+	if err = envCfg.applyEnv(); err != nil {
+		errs = append(errs, err)
+	}
+	if err = envCfg.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("environment configuration ignored: %w", err))
+	} else {
+		cfg = envCfg
+	}
+
+	currentConfig.Store(cfg)
+
+	return errors.Join(errs...)
+}
+
+// Applies a partial JSON configuration over the current one, nothing is changed if the result is not valid.
+// Values are read when used, so changes affect new subscriptions, writers, PLIs, log levels and log rotation,
+// but the log format and the metrics listener only use them at startup.
+func setConfigImpl(update string) string {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	cfg := getConfig().clone()
+	if err := json.Unmarshal([]byte(update), cfg); err != nil {
+		root.logger.Warnw("setConfigImpl: invalid configuration", err)
+		return "ERROR: " + err.Error()
+	}
+	if err := cfg.validate(); err != nil {
+		root.logger.Warnw("setConfigImpl: invalid configuration", err)
+		return "ERROR: " + err.Error()
+	}
+	currentConfig.Store(cfg)
+
+	result, _ := json.Marshal(cfg)
+	root.logger.Infow(fmt.Sprintf("setConfigImpl: configuration updated %s", string(result)))
+	return string(result)
+}
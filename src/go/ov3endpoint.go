@@ -7,8 +7,10 @@ import "C"
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/go-gst/go-glib/glib"
@@ -234,7 +236,17 @@ func WrapBin(bin *C.GstBin) *gst.Bin {
 }
 
 func subscribeParticipantImpl(participantId string, screenShare bool, egressId string, audioSourceC *C.GstBin, videoSourceC *C.GstBin) string {
+	return subscribeParticipantWithOptionsImpl(participantId, screenShare, egressId, audioSourceC, videoSourceC, "")
+}
+
+func subscribeParticipantWithOptionsImpl(participantId string, screenShare bool, egressId string, audioSourceC *C.GstBin, videoSourceC *C.GstBin, optionsStr string) string {
 	root.logger.Debugw(fmt.Sprintf("subscribeParticipantImpl: participant %s, egress Id %s, and screenshare %t", participantId, egressId, screenShare))
+	options, err := parseSubscriberOptions(optionsStr)
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("subscribeParticipantImpl: invalid options for participant %s", participantId), err)
+		return "ERROR: Invalid subscribe options " + err.Error()
+	}
+
 	roomSvc := root.getEgress(egressId)
 	if roomSvc == nil {
 		return "ERROR: Egress " + egressId + "  not avilable in service "
@@ -243,7 +255,7 @@ func subscribeParticipantImpl(participantId string, screenShare bool, egressId s
 	audioSource := WrapBin(audioSourceC)
 	videoSource := WrapBin(videoSourceC)
 
-	subscriber := roomSvc.addSubscriber(participantId, screenShare, audioSource, videoSource)
+	subscriber := roomSvc.addSubscriber(participantId, screenShare, audioSource, videoSource, options)
 	root.addSubscriber(subscriber.id, subscriber)
 
 	return subscriber.id
@@ -264,6 +276,219 @@ func requestKeyFrameImpl(subscriberId string) {
 	}
 }
 
+// Subscribers of the egress share a common timeline, so compositions and recordings of several participants
+// line up. Existing subscribers are moved to it, late joiners keep their running time and take the room PTS
+func setRoomAlignmentImpl(egressId string, aligned bool) string {
+	root.logger.Debugw(fmt.Sprintf("setRoomAlignmentImpl: egress Id %s, aligned %t", egressId, aligned))
+	roomSvc := root.getEgress(egressId)
+	if roomSvc == nil {
+		return "ERROR: Egress " + egressId + " is not available"
+	}
+
+	roomSvc.Lock()
+	if aligned && !roomSvc.aligned {
+		roomSvc.alignSubscribers()
+	}
+	roomSvc.aligned = aligned
+	roomSvc.Unlock()
+
+	return egressId
+}
+
+func createAudioMixerImpl(egressId string, audioSinkC *C.GstBin, optionsStr string) string {
+	root.logger.Debugw(fmt.Sprintf("createAudioMixerImpl: egress Id %s, options %s", egressId, optionsStr))
+	options, err := parseMixerOptions(optionsStr)
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("createAudioMixerImpl: invalid options for egress %s", egressId), err)
+		return "ERROR: Invalid mixer options " + err.Error()
+	}
+
+	roomSvc := root.getEgress(egressId)
+	if roomSvc == nil {
+		return "ERROR: Egress " + egressId + " is not available"
+	}
+
+	mixer, err := newAudioMixer(roomSvc, WrapBin(audioSinkC), options)
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("createAudioMixerImpl: cannot create mixer for egress %s", egressId), err)
+		return "ERROR: Cannot create audio mixer " + err.Error()
+	}
+	roomSvc.addMixer(mixer)
+	root.addMixer(mixer.id, mixer)
+	mixer.start()
+
+	return mixer.id
+}
+
+func setAudioMixerGainImpl(mixerId string, participantId string, gain float64) string {
+	root.logger.Debugw(fmt.Sprintf("setAudioMixerGainImpl: mixer %s, participant %s, gain %f", mixerId, participantId, gain))
+	mixer := root.getMixer(mixerId)
+	if mixer == nil {
+		return "ERROR: Mixer with id " + mixerId + " does not exist"
+	}
+	if err := mixer.setGain(participantId, gain); err != nil {
+		return "ERROR: " + err.Error()
+	}
+
+	return mixerId
+}
+
+func destroyAudioMixerImpl(mixerId string) string {
+	root.logger.Debugw(fmt.Sprintf("destroyAudioMixerImpl: mixer %s", mixerId))
+	mixer := root.deleteMixer(mixerId)
+	if mixer == nil {
+		return "ERROR: Mixer with id " + mixerId + " does not exist"
+	}
+	mixer.room.deleteMixer(mixerId)
+	mixer.destroy()
+
+	return mixerId
+}
+
+func createVideoCompositeImpl(egressId string, videoSinkC *C.GstBin, optionsStr string) string {
+	root.logger.Debugw(fmt.Sprintf("createVideoCompositeImpl: egress Id %s, options %s", egressId, optionsStr))
+	options, err := parseCompositeOptions(optionsStr)
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("createVideoCompositeImpl: invalid options for egress %s", egressId), err)
+		return "ERROR: Invalid composite options " + err.Error()
+	}
+
+	roomSvc := root.getEgress(egressId)
+	if roomSvc == nil {
+		return "ERROR: Egress " + egressId + " is not available"
+	}
+
+	composite, err := newVideoComposite(roomSvc, WrapBin(videoSinkC), options)
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("createVideoCompositeImpl: cannot create composite for egress %s", egressId), err)
+		return "ERROR: Cannot create video composite " + err.Error()
+	}
+	roomSvc.addComposite(composite)
+	root.addComposite(composite.id, composite)
+	composite.start()
+
+	return composite.id
+}
+
+func setCompositeLayoutImpl(compositeId string, layout string) string {
+	root.logger.Debugw(fmt.Sprintf("setCompositeLayoutImpl: composite %s, layout %s", compositeId, layout))
+	composite := root.getComposite(compositeId)
+	if composite == nil {
+		return "ERROR: Composite with id " + compositeId + " does not exist"
+	}
+	if err := composite.setLayout(layout); err != nil {
+		return "ERROR: " + err.Error()
+	}
+
+	return compositeId
+}
+
+func destroyVideoCompositeImpl(compositeId string) string {
+	root.logger.Debugw(fmt.Sprintf("destroyVideoCompositeImpl: composite %s", compositeId))
+	composite := root.deleteComposite(compositeId)
+	if composite == nil {
+		return "ERROR: Composite with id " + compositeId + " does not exist"
+	}
+	composite.room.deleteComposite(compositeId)
+	composite.destroy()
+
+	return compositeId
+}
+
+func startRecordingImpl(egressId string, participantId string, screenShare bool, optionsStr string) string {
+	root.logger.Debugw(fmt.Sprintf("startRecordingImpl: egress Id %s, participant %s, screenshare %t, options %s", egressId, participantId, screenShare, optionsStr))
+	options, err := parseRecordingOptions(optionsStr)
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("startRecordingImpl: invalid options for participant %s", participantId), err)
+		return "ERROR: Invalid recording options " + err.Error()
+	}
+
+	roomSvc := root.getEgress(egressId)
+	if roomSvc == nil {
+		return "ERROR: Egress " + egressId + " is not available"
+	}
+
+	recorder, err := newRecorder(roomSvc, participantId, screenShare, options)
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("startRecordingImpl: cannot create recording for participant %s", participantId), err)
+		return "ERROR: Cannot create recording " + err.Error()
+	}
+	roomSvc.addRecorder(recorder)
+	root.addRecording(recorder.id, recorder)
+	if err = recorder.start(); err != nil {
+		root.logger.Errorw(fmt.Sprintf("startRecordingImpl: cannot start recording for participant %s", participantId), err)
+		recorder.finish(err.Error())
+		return "ERROR: Cannot start recording " + err.Error()
+	}
+
+	return recorder.id
+}
+
+func splitRecordingImpl(recordingId string) string {
+	root.logger.Debugw(fmt.Sprintf("splitRecordingImpl: recording %s", recordingId))
+	recorder := root.getRecording(recordingId)
+	if recorder == nil {
+		return "ERROR: Recording with id " + recordingId + " does not exist"
+	}
+	if err := recorder.split(); err != nil {
+		return "ERROR: " + err.Error()
+	}
+
+	return recordingId
+}
+
+// Finalizing is asynchronous, completion is reported by getRecordingStatus
+func stopRecordingImpl(recordingId string) string {
+	root.logger.Debugw(fmt.Sprintf("stopRecordingImpl: recording %s", recordingId))
+	recorder := root.getRecording(recordingId)
+	if recorder == nil {
+		return "ERROR: Recording with id " + recordingId + " does not exist"
+	}
+	if err := recorder.stop(); err != nil {
+		return "ERROR: " + err.Error()
+	}
+
+	return recordingId
+}
+
+func getRecordingStatusImpl(recordingId string) string {
+	recorder := root.getRecording(recordingId)
+	if recorder == nil {
+		return "ERROR: Recording with id " + recordingId + " does not exist"
+	}
+	snapshot := recorder.snapshot()
+	status, err := json.Marshal(snapshot)
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("getRecordingStatusImpl: could not marshal status of %s", recordingId), err)
+		return "ERROR: " + err.Error()
+	}
+	// The completion status is only reported once, after that the recording is forgotten
+	if (snapshot.State == recordingCompleted) || (snapshot.State == recordingFailed) {
+		root.deleteRecording(recordingId)
+	}
+
+	return string(status)
+}
+
+// For callers not waiting for the completion status, an active recording is stopped and finalizes on its own
+func releaseRecordingImpl(recordingId string) string {
+	root.logger.Debugw(fmt.Sprintf("releaseRecordingImpl: recording %s", recordingId))
+	recorder := root.deleteRecording(recordingId)
+	if recorder == nil {
+		return "ERROR: Recording with id " + recordingId + " does not exist"
+	}
+	recorder.Lock()
+	active := recorder.state == recordingActive
+	recorder.Unlock()
+	if active {
+		if err := recorder.stop(); err != nil {
+			root.logger.Warnw(fmt.Sprintf("releaseRecordingImpl: could not stop recording %s", recordingId), err)
+		}
+	}
+
+	return recordingId
+}
+
 func unsubscribeParticipantImpl(subscriberId string) string {
 	root.logger.Debugw(fmt.Sprintf("unsubscribeParticipantImpl: subscriberId %s", subscriberId))
 	subscriber := root.getSubscriber(subscriberId)
@@ -279,20 +504,33 @@ func unsubscribeParticipantImpl(subscriberId string) string {
 }
 
 func publishParticipantImpl(screenShare bool, ingressId string, audioSinkC *C.GstBin, videoSinkC *C.GstBin) string {
-	var err error
-	var publisher *ov3Publisher
 	var audioSink *gst.Bin
 	var videoSink *gst.Bin
-	var mediaMode string
 
 	if audioSinkC != nil {
-		if videoSinkC != nil {
+		audioSink = WrapBin(audioSinkC)
+	}
+
+	if videoSinkC != nil {
+		videoSink = WrapBin(videoSinkC)
+	}
+
+	return publishBinsImpl(screenShare, ingressId, audioSink, videoSink)
+}
+
+func publishBinsImpl(screenShare bool, ingressId string, audioSink *gst.Bin, videoSink *gst.Bin) string {
+	var err error
+	var publisher *ov3Publisher
+	var mediaMode string
+
+	if audioSink != nil {
+		if videoSink != nil {
 			mediaMode = "Audio + Video"
 		} else {
 			mediaMode = "Audio"
 		}
 	} else {
-		if videoSinkC != nil {
+		if videoSink != nil {
 			mediaMode = "Video"
 		} else {
 			mediaMode = "No media"
@@ -316,14 +554,6 @@ func publishParticipantImpl(screenShare bool, ingressId string, audioSinkC *C.Gs
 		return "ERROR: Already publishing"
 	}
 
-	if audioSinkC != nil {
-		audioSink = WrapBin(audioSinkC)
-	}
-
-	if videoSinkC != nil {
-		videoSink = WrapBin(videoSinkC)
-	}
-
 	if screenShare {
 		root.logger.Debugw(fmt.Sprintf("publishParticipantImpl: ingress %s  publishing screenshare", ingressId))
 		publisher, err = ing.PublishScreenShare(audioSink, videoSink)
@@ -384,6 +614,104 @@ func unpublishParticipantImpl(screenShare bool, publisherId string) string {
 	return publisherId
 }
 
+func setPublisherMutedImpl(publisherId string, kind string, muted bool) string {
+	root.logger.Debugw(fmt.Sprintf("setPublisherMutedImpl: publisher Id %s, kind %s, muted %t", publisherId, kind, muted))
+	publisher := root.getPublisher(publisherId)
+	if publisher == nil {
+		return "ERROR: publisher not found"
+	}
+	if err := publisher.setMuted(lksdk.TrackKind(kind), muted); err != nil {
+		root.logger.Errorw(fmt.Sprintf("setPublisherMutedImpl: cannot mute publisher %s", publisherId), err)
+		return "ERROR: " + err.Error()
+	}
+
+	return publisherId
+}
+
+func startStreamIngestImpl(ingressId string, source string, screenShare bool, optionsStr string) string {
+	root.logger.Debugw(fmt.Sprintf("startStreamIngestImpl: ingress Id %s, screenshare %t, options %s", ingressId, screenShare, optionsStr))
+	if err := validateIngestUrl(source); err != nil {
+		root.logger.Errorw(fmt.Sprintf("startStreamIngestImpl: invalid source for ingress %s", ingressId), err)
+		return "ERROR: Invalid ingest source " + err.Error()
+	}
+	options, err := parseIngestOptions(optionsStr)
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("startStreamIngestImpl: invalid options for ingress %s", ingressId), err)
+		return "ERROR: Invalid ingest options " + err.Error()
+	}
+
+	ingest, err := newStreamIngest(ingressId, source, screenShare, options)
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("startStreamIngestImpl: cannot create ingest for ingress %s", ingressId), err)
+		return "ERROR: Cannot create stream ingest " + err.Error()
+	}
+	if err = ingest.start(); err != nil {
+		root.logger.Errorw(fmt.Sprintf("startStreamIngestImpl: cannot start ingest for ingress %s", ingressId), err)
+		ingest.stop()
+		return "ERROR: Cannot start stream ingest " + err.Error()
+	}
+	root.addStreamIngest(ingest.id, ingest)
+
+	return ingest.id
+}
+
+func stopStreamIngestImpl(ingestId string) string {
+	root.logger.Debugw(fmt.Sprintf("stopStreamIngestImpl: ingest %s", ingestId))
+	ingest := root.deleteStreamIngest(ingestId)
+	if ingest == nil {
+		return "ERROR: Stream ingest with id " + ingestId + " does not exist"
+	}
+	ingest.stop()
+
+	return ingestId
+}
+
+func publishFileImpl(ingressId string, path string, loop bool, screenShare bool) string {
+	root.logger.Debugw(fmt.Sprintf("publishFileImpl: ingress Id %s, file %s, loop %t, screenshare %t", ingressId, path, loop, screenShare))
+	ingest, err := newFileIngest(ingressId, path, loop, screenShare)
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("publishFileImpl: cannot open file %s for ingress %s", path, ingressId), err)
+		return "ERROR: Cannot publish file " + err.Error()
+	}
+	if err = ingest.start(); err != nil {
+		root.logger.Errorw(fmt.Sprintf("publishFileImpl: cannot start file %s for ingress %s", path, ingressId), err)
+		ingest.stop()
+		return "ERROR: Cannot publish file " + err.Error()
+	}
+	root.addStreamIngest(ingest.id, ingest)
+
+	return ingest.id
+}
+
+func pauseFileImpl(fileId string, paused bool) string {
+	root.logger.Debugw(fmt.Sprintf("pauseFileImpl: file %s, paused %t", fileId, paused))
+	ingest := root.getStreamIngest(fileId)
+	if ingest == nil {
+		return "ERROR: File publisher with id " + fileId + " does not exist"
+	}
+	if err := ingest.setPaused(paused); err != nil {
+		return "ERROR: " + err.Error()
+	}
+
+	return fileId
+}
+
+func seekFileImpl(fileId string, position int64) string {
+	root.logger.Debugw(fmt.Sprintf("seekFileImpl: file %s, position %d ms", fileId, position))
+	ingest := root.getStreamIngest(fileId)
+	if ingest == nil {
+		return "ERROR: File publisher with id " + fileId + " does not exist"
+	}
+	if position < 0 {
+		return "ERROR: Invalid position " + fmt.Sprint(position)
+	}
+	if err := ingest.seek(time.Duration(position) * time.Millisecond); err != nil {
+		return "ERROR: " + err.Error()
+	}
+
+	return fileId
+}
+
 //export connectToRoom
 func connectToRoom(url *C.char, key *C.char, secret *C.char, room *C.char, publisherName *C.char, publisherId *C.char) (ret *C.char) {
 	defer func() {
@@ -448,6 +776,27 @@ func subscribeParticipant(participantId *C.char, screenShare bool, egressId *C.c
 	return C.CString(result)
 }
 
+//export subscribeParticipantWithOptions
+func subscribeParticipantWithOptions(participantId *C.char, screenShare bool, egressId *C.char, audioSourceC *C.GstBin, videoSourceC *C.GstBin, options *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on subscribeParticipantWithOptions ", err))
+			root.logger.Infow("subscribeParticipantWithOptions: error subscribing")
+			ret = C.CString("ERROR: Panic subscribing")
+		}
+	}()
+	var optionsStr string
+
+	if options == nil {
+		optionsStr = ""
+	} else {
+		optionsStr = C.GoString(options)
+	}
+	result := subscribeParticipantWithOptionsImpl(C.GoString(participantId), screenShare, C.GoString(egressId), audioSourceC, videoSourceC, optionsStr)
+
+	return C.CString(result)
+}
+
 //export requestKeyFrame
 func requestKeyFrame(subscriberId *C.char) {
 	defer func() {
@@ -459,6 +808,195 @@ func requestKeyFrame(subscriberId *C.char) {
 	requestKeyFrameImpl(C.GoString(subscriberId))
 }
 
+//export setRoomAlignment
+func setRoomAlignment(egressId *C.char, aligned bool) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on setRoomAlignment ", err))
+			root.logger.Infow("setRoomAlignment: error setting alignment")
+			ret = C.CString("ERROR: Panic setting room alignment")
+		}
+	}()
+	result := setRoomAlignmentImpl(C.GoString(egressId), aligned)
+
+	return C.CString(result)
+}
+
+//export createAudioMixer
+func createAudioMixer(egressId *C.char, audioSink *C.GstBin, options *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on createAudioMixer ", err))
+			root.logger.Infow("createAudioMixer: error creating mixer")
+			ret = C.CString("ERROR: Panic creating audio mixer")
+		}
+	}()
+	var optionsStr string
+
+	if options == nil {
+		optionsStr = ""
+	} else {
+		optionsStr = C.GoString(options)
+	}
+	result := createAudioMixerImpl(C.GoString(egressId), audioSink, optionsStr)
+
+	return C.CString(result)
+}
+
+//export setAudioMixerGain
+func setAudioMixerGain(mixerId *C.char, participantId *C.char, gain float64) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on setAudioMixerGain ", err))
+			root.logger.Infow("setAudioMixerGain: error setting gain")
+			ret = C.CString("ERROR: Panic setting mixer gain")
+		}
+	}()
+	result := setAudioMixerGainImpl(C.GoString(mixerId), C.GoString(participantId), gain)
+
+	return C.CString(result)
+}
+
+//export destroyAudioMixer
+func destroyAudioMixer(mixerId *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on destroyAudioMixer ", err))
+			root.logger.Infow("destroyAudioMixer: error destroying mixer")
+			ret = C.CString("ERROR: Panic destroying audio mixer")
+		}
+	}()
+	result := destroyAudioMixerImpl(C.GoString(mixerId))
+
+	return C.CString(result)
+}
+
+//export createVideoComposite
+func createVideoComposite(egressId *C.char, videoSink *C.GstBin, options *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on createVideoComposite ", err))
+			root.logger.Infow("createVideoComposite: error creating composite")
+			ret = C.CString("ERROR: Panic creating video composite")
+		}
+	}()
+	var optionsStr string
+
+	if options == nil {
+		optionsStr = ""
+	} else {
+		optionsStr = C.GoString(options)
+	}
+	result := createVideoCompositeImpl(C.GoString(egressId), videoSink, optionsStr)
+
+	return C.CString(result)
+}
+
+//export setCompositeLayout
+func setCompositeLayout(compositeId *C.char, layout *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on setCompositeLayout ", err))
+			root.logger.Infow("setCompositeLayout: error setting layout")
+			ret = C.CString("ERROR: Panic setting composite layout")
+		}
+	}()
+	result := setCompositeLayoutImpl(C.GoString(compositeId), C.GoString(layout))
+
+	return C.CString(result)
+}
+
+//export destroyVideoComposite
+func destroyVideoComposite(compositeId *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on destroyVideoComposite ", err))
+			root.logger.Infow("destroyVideoComposite: error destroying composite")
+			ret = C.CString("ERROR: Panic destroying video composite")
+		}
+	}()
+	result := destroyVideoCompositeImpl(C.GoString(compositeId))
+
+	return C.CString(result)
+}
+
+//export startRecording
+func startRecording(egressId *C.char, participantId *C.char, screenShare bool, options *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on startRecording ", err))
+			root.logger.Infow("startRecording: error starting recording")
+			ret = C.CString("ERROR: Panic starting recording")
+		}
+	}()
+	var optionsStr string
+
+	if options == nil {
+		optionsStr = ""
+	} else {
+		optionsStr = C.GoString(options)
+	}
+	result := startRecordingImpl(C.GoString(egressId), C.GoString(participantId), screenShare, optionsStr)
+
+	return C.CString(result)
+}
+
+//export splitRecording
+func splitRecording(recordingId *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on splitRecording ", err))
+			root.logger.Infow("splitRecording: error splitting recording")
+			ret = C.CString("ERROR: Panic splitting recording")
+		}
+	}()
+	result := splitRecordingImpl(C.GoString(recordingId))
+
+	return C.CString(result)
+}
+
+//export stopRecording
+func stopRecording(recordingId *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on stopRecording ", err))
+			root.logger.Infow("stopRecording: error stopping recording")
+			ret = C.CString("ERROR: Panic stopping recording")
+		}
+	}()
+	result := stopRecordingImpl(C.GoString(recordingId))
+
+	return C.CString(result)
+}
+
+//export getRecordingStatus
+func getRecordingStatus(recordingId *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on getRecordingStatus ", err))
+			root.logger.Infow("getRecordingStatus: error getting recording status")
+			ret = C.CString("ERROR: Panic getting recording status")
+		}
+	}()
+	result := getRecordingStatusImpl(C.GoString(recordingId))
+
+	return C.CString(result)
+}
+
+//export releaseRecording
+func releaseRecording(recordingId *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on releaseRecording ", err))
+			root.logger.Infow("releaseRecording: error releasing recording")
+			ret = C.CString("ERROR: Panic releasing recording")
+		}
+	}()
+	result := releaseRecordingImpl(C.GoString(recordingId))
+
+	return C.CString(result)
+}
+
 //export unsubscribeParticipant
 func unsubscribeParticipant(subscriberId *C.char) (ret *C.char) {
 	defer func() {
@@ -503,4 +1041,151 @@ func unpublishParticipant(screenShare bool, publisherId *C.char) (ret *C.char) {
 	return C.CString(result)
 }
 
+//export setPublisherMuted
+func setPublisherMuted(publisherId *C.char, kind *C.char, muted bool) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on setPublisherMuted ", err))
+			root.logger.Infow("setPublisherMuted: error muting publisher")
+			ret = C.CString("ERROR: Panic muting publisher")
+		}
+	}()
+	result := setPublisherMutedImpl(C.GoString(publisherId), C.GoString(kind), muted)
+
+	return C.CString(result)
+}
+
+//export startStreamIngest
+func startStreamIngest(ingressId *C.char, source *C.char, screenShare bool, options *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on startStreamIngest ", err))
+			root.logger.Infow("startStreamIngest: error starting stream ingest")
+			ret = C.CString("ERROR: Panic starting stream ingest")
+		}
+	}()
+	var optionsStr string
+
+	if options == nil {
+		optionsStr = ""
+	} else {
+		optionsStr = C.GoString(options)
+	}
+	result := startStreamIngestImpl(C.GoString(ingressId), C.GoString(source), screenShare, optionsStr)
+
+	return C.CString(result)
+}
+
+//export stopStreamIngest
+func stopStreamIngest(ingestId *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on stopStreamIngest ", err))
+			root.logger.Infow("stopStreamIngest: error stopping stream ingest")
+			ret = C.CString("ERROR: Panic stopping stream ingest")
+		}
+	}()
+	result := stopStreamIngestImpl(C.GoString(ingestId))
+
+	return C.CString(result)
+}
+
+//export publishFile
+func publishFile(ingressId *C.char, path *C.char, loop bool, screenShare bool) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on publishFile ", err))
+			root.logger.Infow("publishFile: error publishing file")
+			ret = C.CString("ERROR: Panic publishing file")
+		}
+	}()
+	result := publishFileImpl(C.GoString(ingressId), C.GoString(path), loop, screenShare)
+
+	return C.CString(result)
+}
+
+//export pauseFile
+func pauseFile(fileId *C.char, paused bool) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on pauseFile ", err))
+			root.logger.Infow("pauseFile: error pausing file")
+			ret = C.CString("ERROR: Panic pausing file")
+		}
+	}()
+	result := pauseFileImpl(C.GoString(fileId), paused)
+
+	return C.CString(result)
+}
+
+//export seekFile
+func seekFile(fileId *C.char, position int64) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on seekFile ", err))
+			root.logger.Infow("seekFile: error seeking file")
+			ret = C.CString("ERROR: Panic seeking file")
+		}
+	}()
+	result := seekFileImpl(C.GoString(fileId), position)
+
+	return C.CString(result)
+}
+
+//export unpublishFile
+func unpublishFile(fileId *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on unpublishFile ", err))
+			root.logger.Infow("unpublishFile: error unpublishing file")
+			ret = C.CString("ERROR: Panic unpublishing file")
+		}
+	}()
+	result := stopStreamIngestImpl(C.GoString(fileId))
+
+	return C.CString(result)
+}
+
+//export setEventCallback
+func setEventCallback(callback unsafe.Pointer) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on setEventCallback ", err))
+			root.logger.Infow("setEventCallback: error setting callback")
+			ret = C.CString("ERROR: Panic setting event callback")
+		}
+	}()
+	result := setEventCallbackImpl(callback)
+
+	return C.CString(result)
+}
+
+//export dumpState
+func dumpState() (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on dumpState ", err))
+			root.logger.Infow("dumpState: error dumping state")
+			ret = C.CString("ERROR: Panic dumping state")
+		}
+	}()
+	result := dumpStateImpl()
+
+	return C.CString(result)
+}
+
+//export setConfig
+func setConfig(config *C.char) (ret *C.char) {
+	defer func() {
+		if err := recover(); err != nil {
+			root.logger.Infow(fmt.Sprintln("panic occurred on setConfig ", err))
+			root.logger.Infow("setConfig: error setting configuration")
+			ret = C.CString("ERROR: Panic setting configuration")
+		}
+	}()
+	result := setConfigImpl(C.GoString(config))
+
+	return C.CString(result)
+}
+
 func main() {}
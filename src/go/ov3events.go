@@ -0,0 +1,106 @@
+package main
+
+/*
+#include <stdlib.h>
+
+typedef void (*ov3EventCallback) (char *source, char *name, char *data);
+
+static void
+callEventCallback (void *callback, char *source, char *name, char *data)
+{
+  ((ov3EventCallback) callback) (source, name, data);
+}
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const eventQueueSize = 256
+
+// Notification for the application, source is the id returned when the object was created and data a
+// JSON object with event specific fields
+type ov3Event struct {
+	source string
+	name   string
+	data   map[string]interface{}
+}
+
+// Events are delivered in order from a single goroutine, so the callback is never entered from a
+// streaming thread or with any of our locks held
+type ov3Events struct {
+	sync.RWMutex
+	callback unsafe.Pointer
+	queue    chan ov3Event
+	start    sync.Once
+	dropped  atomic.Uint64
+}
+
+var events = ov3Events{
+	queue: make(chan ov3Event, eventQueueSize),
+}
+
+func (ev *ov3Events) setCallback(callback unsafe.Pointer) {
+	ev.Lock()
+	ev.callback = callback
+	ev.Unlock()
+
+	ev.start.Do(func() {
+		go ev.dispatch()
+	})
+}
+
+func (ev *ov3Events) dispatch() {
+	for event := range ev.queue {
+		ev.RLock()
+		callback := ev.callback
+		ev.RUnlock()
+		if callback == nil {
+			continue
+		}
+
+		data, err := json.Marshal(event.data)
+		if err != nil {
+			root.logger.Errorw(fmt.Sprintf("dispatch: cannot marshal event %s from %s", event.name, event.source), err)
+			continue
+		}
+		cSource := C.CString(event.source)
+		cName := C.CString(event.name)
+		cData := C.CString(string(data))
+		C.callEventCallback(callback, cSource, cName, cData)
+		C.free(unsafe.Pointer(cSource))
+		C.free(unsafe.Pointer(cName))
+		C.free(unsafe.Pointer(cData))
+	}
+}
+
+// Never blocks, events are dropped when the application does not keep up
+func emitEvent(source string, name string, data map[string]interface{}) {
+	root.logger.Infow(fmt.Sprintf("emitEvent: %s from %s %v", name, source, data))
+
+	events.RLock()
+	registered := events.callback != nil
+	events.RUnlock()
+	if !registered {
+		return
+	}
+
+	select {
+	case events.queue <- ov3Event{source: source, name: name, data: data}:
+	default:
+		events.dropped.Add(1)
+		root.logger.Warnw(fmt.Sprintf("emitEvent: queue full, dropping %s from %s", name, source), nil)
+	}
+}
+
+func setEventCallbackImpl(callback unsafe.Pointer) string {
+	root.logger.Debugw(fmt.Sprintf("setEventCallbackImpl: callback registered %t", callback != nil))
+	events.setCallback(callback)
+
+	return "OK"
+}
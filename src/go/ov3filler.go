@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+// Subscriber filler modes, audio is filled with silence on any of them
+const (
+	fillerNone   = ""
+	fillerFrozen = "frozen" // last keyframe received, black until there is one
+	fillerBlack  = "black"
+)
+
+const (
+	fillerAudioInterval = 20 * time.Millisecond
+	fillerVideoInterval = 66 * time.Millisecond
+	// Size of the black frame when the depayloaded caps do not tell the one of the live stream
+	fillerBlackWidth  = 640
+	fillerBlackHeight = 360
+)
+
+// 20 ms Opus frame decoding to silence
+var opusSilenceFrame = []byte{0xf8, 0xff, 0xfe}
+
+// 20 ms of G.711 silence at 8 kHz
+var (
+	mulawSilenceFrame = bytes.Repeat([]byte{0xff}, 160)
+	alawSilenceFrame  = bytes.Repeat([]byte{0xd5}, 160)
+)
+
+// Encoders used to make the black frame for each depayloaded video format
+var fillerBlackEncoders = map[string]string{
+	"video/x-vp8":  "vp8enc deadline=1",
+	"video/x-vp9":  "vp9enc deadline=1",
+	"video/x-h264": "x264enc tune=zerolatency speed-preset=ultrafast ! video/x-h264,profile=constrained-baseline,stream-format=byte-stream,alignment=au",
+}
+
+type fillerFrame struct {
+	data []byte
+	caps *gst.Caps
+}
+
+// Black frames are encoded once per codec and size
+var fillerBlackFrames = struct {
+	sync.Mutex
+	frames map[string]fillerFrame
+}{frames: make(map[string]fillerFrame)}
+
+func validFillerMode(mode string) bool {
+	return (mode == fillerNone) || (mode == fillerFrozen) || (mode == fillerBlack)
+}
+
+// Keeps media flowing out of a subscriber bin while the remote track is muted or in a gap, so encoders,
+// muxers and RTMP pushers downstream do not time out. An input-selector exposed as the bin src pad
+// switches between the depayloader and an appsrc pushing filler frames, and goes back to the
+// depayloader on its next keyframe.
+type ov3Filler struct {
+	sync.Mutex
+	subscriber *ov3Subscriber
+	kind       lksdk.TrackKind
+	mode       string
+	bin        *gst.Bin
+	selector   *gst.Element
+	source     *app.Source
+	livePad    *gst.Pad
+	fillerPad  *gst.Pad
+
+	// Depayloaded stream, taken from the live chain
+	caps     *gst.Caps
+	keyframe []byte
+
+	active bool
+	stop   chan struct{}
+}
+
+func newFiller(subscriber *ov3Subscriber, kind lksdk.TrackKind, mode string, bin *gst.Bin) (*ov3Filler, error) {
+	var err error
+
+	filler := &ov3Filler{
+		subscriber: subscriber,
+		kind:       kind,
+		mode:       mode,
+		bin:        bin,
+	}
+	if filler.selector, err = gst.NewElementWithName("input-selector", fmt.Sprintf("filler_selector_%s", kind)); err != nil {
+		return nil, err
+	}
+	filler.selector.SetProperty("sync-streams", false)
+	// Caps are set from the live stream when filling starts
+	if filler.source, err = createAppSrc("ANY", fmt.Sprintf("filler_src_%s", kind)); err != nil {
+		return nil, err
+	}
+	filler.source.SetProperty("do-timestamp", true)
+	filler.source.SetProperty("block", false)
+
+	if err = bin.AddMany(filler.selector, filler.source.Element); err != nil {
+		return nil, err
+	}
+	// First requested pad is the active one
+	filler.livePad = filler.selector.GetRequestPad("sink_%u")
+	filler.fillerPad = filler.selector.GetRequestPad("sink_%u")
+	if (filler.livePad == nil) || (filler.fillerPad == nil) {
+		return nil, errors.New("cannot get sink pads from input-selector")
+	}
+	if ret := filler.source.GetStaticPad("src").Link(filler.fillerPad); ret != gst.PadLinkOK {
+		return nil, fmt.Errorf("cannot link filler source: %s", ret.String())
+	}
+	if err = exposeSrcInBin(filler.selector, bin); err != nil {
+		return nil, err
+	}
+	filler.selector.SyncStateWithParent()
+	filler.source.Element.SyncStateWithParent()
+
+	return filler, nil
+}
+
+// Links the depayloader of a (re)built track chain to the live input of the selector
+func (filler *ov3Filler) attach(depayloader *gst.Element) error {
+	srcPad := depayloader.GetStaticPad("src")
+	if srcPad == nil {
+		return errors.New("cannot get src pad from depayloader")
+	}
+	if ret := srcPad.Link(filler.livePad); ret != gst.PadLinkOK {
+		return fmt.Errorf("cannot link depayloader to filler: %s", ret.String())
+	}
+
+	filler.Lock()
+	// A new chain may be a different codec, frames of the previous one are not valid
+	filler.caps = nil
+	filler.keyframe = nil
+	filler.Unlock()
+
+	srcPad.AddProbe(gst.PadProbeTypeBuffer, func(pad *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		buffer := info.GetBuffer()
+		if buffer != nil {
+			filler.liveBuffer(pad, buffer)
+		}
+		return gst.PadProbeOK
+	})
+	return nil
+}
+
+// Called from the streaming thread of the depayloader
+func (filler *ov3Filler) liveBuffer(pad *gst.Pad, buffer *gst.Buffer) {
+	keyframe := (filler.kind == lksdk.TrackKindAudio) || !buffer.HasFlags(gst.BufferFlagDeltaUnit)
+
+	filler.Lock()
+	if (filler.caps == nil) || (keyframe && (filler.kind == lksdk.TrackKindVideo)) {
+		filler.caps = pad.GetCurrentCaps()
+	}
+	if keyframe && (filler.kind == lksdk.TrackKindVideo) && (filler.mode == fillerFrozen) {
+		filler.keyframe = buffer.Bytes()
+	}
+	resume := filler.active && keyframe
+	filler.Unlock()
+
+	if resume {
+		filler.deactivate()
+	}
+}
+
+// Frame pushed while filling, nil if none is available yet
+func (filler *ov3Filler) frame() *fillerFrame {
+	filler.Lock()
+	caps := filler.caps
+	keyframe := filler.keyframe
+	filler.Unlock()
+
+	if caps == nil {
+		return nil
+	}
+	if filler.kind == lksdk.TrackKindAudio {
+		switch caps.GetStructureAt(0).Name() {
+		case "audio/x-mulaw":
+			return &fillerFrame{data: mulawSilenceFrame, caps: caps}
+		case "audio/x-alaw":
+			return &fillerFrame{data: alawSilenceFrame, caps: caps}
+		default:
+			return &fillerFrame{data: opusSilenceFrame, caps: caps}
+		}
+	}
+	if keyframe != nil {
+		return &fillerFrame{data: keyframe, caps: caps}
+	}
+	frame, err := blackFrame(caps)
+	if err != nil {
+		root.logger.Warnw(fmt.Sprintf("frame: no black frame for subscriber %s", filler.subscriber.id), err)
+		return nil
+	}
+	return frame
+}
+
+func (filler *ov3Filler) activate() {
+	filler.Lock()
+	if filler.active {
+		filler.Unlock()
+		return
+	}
+	filler.Unlock()
+
+	frame := filler.frame()
+	if frame == nil {
+		root.logger.Debugw(fmt.Sprintf("activate: nothing received yet on %s of subscriber %s, no filler", filler.kind, filler.subscriber.id))
+		return
+	}
+
+	filler.Lock()
+	defer filler.Unlock()
+	if filler.active {
+		return
+	}
+	filler.active = true
+	filler.stop = make(chan struct{})
+	filler.source.SetCaps(frame.caps)
+	filler.selector.SetProperty("active-pad", filler.fillerPad)
+	go filler.run(frame, filler.stop)
+
+	root.logger.Debugw(fmt.Sprintf("activate: filling %s of subscriber %s", filler.kind, filler.subscriber.id))
+}
+
+func (filler *ov3Filler) deactivate() {
+	filler.Lock()
+	defer filler.Unlock()
+
+	if !filler.active {
+		return
+	}
+	filler.active = false
+	close(filler.stop)
+	filler.selector.SetProperty("active-pad", filler.livePad)
+
+	root.logger.Debugw(fmt.Sprintf("deactivate: %s of subscriber %s back to live", filler.kind, filler.subscriber.id))
+}
+
+func (filler *ov3Filler) run(frame *fillerFrame, stop chan struct{}) {
+	interval := fillerVideoInterval
+	if filler.kind == lksdk.TrackKindAudio {
+		interval = fillerAudioInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			buffer := gst.NewBufferFromBytes(frame.data)
+			buffer.SetDuration(gst.ClockTime(interval))
+			if flow := filler.source.PushBuffer(buffer); flow != gst.FlowOK {
+				root.logger.Debugw(fmt.Sprintf("run: filler of subscriber %s stopped, flow %s", filler.subscriber.id, flow.String()))
+				return
+			}
+		}
+	}
+}
+
+func (filler *ov3Filler) destroy() {
+	filler.deactivate()
+}
+
+// Width and height of the live stream, even as I420 needs
+func blackFrameSize(structure *gst.Structure) (int64, int64) {
+	width, err := getIntFieldFromGstStructure(structure, "width")
+	if (err != nil) || (width <= 0) {
+		width = fillerBlackWidth
+	}
+	height, err := getIntFieldFromGstStructure(structure, "height")
+	if (err != nil) || (height <= 0) {
+		height = fillerBlackHeight
+	}
+	return width + width%2, height + height%2
+}
+
+// Encodes a single black frame with the codec and size of the subscribed track, so decoders downstream
+// do not see a resolution change
+func blackFrame(caps *gst.Caps) (*fillerFrame, error) {
+	structure := caps.GetStructureAt(0)
+	format := structure.Name()
+	width, height := blackFrameSize(structure)
+	key := fmt.Sprintf("%s %dx%d", format, width, height)
+
+	fillerBlackFrames.Lock()
+	defer fillerBlackFrames.Unlock()
+
+	if frame, ok := fillerBlackFrames.frames[key]; ok {
+		return &frame, nil
+	}
+	encoder, ok := fillerBlackEncoders[format]
+	if !ok {
+		return nil, fmt.Errorf("no black frame encoder for %s", format)
+	}
+
+	description := strings.Join([]string{
+		"videotestsrc pattern=black num-buffers=1",
+		fmt.Sprintf("video/x-raw,format=I420,width=%d,height=%d,framerate=15/1", width, height),
+		encoder,
+		"appsink name=sink sync=false",
+	}, " ! ")
+	pipeline, err := gst.NewPipelineFromString(description)
+	if err != nil {
+		return nil, err
+	}
+	defer pipeline.SetState(gst.StateNull)
+
+	element, err := pipeline.GetElementByName("sink")
+	if err != nil {
+		return nil, err
+	}
+	if err = pipeline.SetState(gst.StatePlaying); err != nil {
+		return nil, err
+	}
+	sample := app.SinkFromElement(element).TryPullSample(gst.ClockTime(5 * time.Second))
+	if (sample == nil) || (sample.GetBuffer() == nil) {
+		return nil, fmt.Errorf("cannot encode black frame for %s", format)
+	}
+
+	frame := fillerFrame{data: sample.GetBuffer().Bytes(), caps: sample.GetCaps()}
+	fillerBlackFrames.frames[key] = frame
+	return &frame, nil
+}
@@ -24,10 +24,12 @@ type ov3Ingress struct {
 
 func (ing *ov3Ingress) lkReconnecting() {
 	root.logger.Debugw("lkReconnecting")
+	metrics.ingressReconnecting.Add(1)
 }
 
 func (ing *ov3Ingress) lkReconnected() {
 	root.logger.Debugw("lkReconnected")
+	metrics.ingressReconnected.Add(1)
 
 	// FIXME: reconnect the ingress and all of its tracks
 }
@@ -38,16 +40,37 @@ func (ing *ov3Ingress) lkDisconnected() {
 	// FIXME: disconnect the ingress and all of its tracks
 }
 
+// Track publisher of this ingress for a published track, nil if the track is not ours
+func (ing *ov3Ingress) getTrackPublisher(sid string) *ov3TrackPublisher {
+	for _, pub := range []*ov3Publisher{ing.mainPub, ing.screenSharePub} {
+		if pub == nil {
+			continue
+		}
+		for _, trPub := range []*ov3TrackPublisher{pub.audioPublisher, pub.videoPublisher} {
+			if (trPub != nil) && (trPub.sid == sid) {
+				return trPub
+			}
+		}
+	}
+	return nil
+}
+
+// Called both for the mutes we signal and for those requested from the server (moderators), the track
+// publisher tells them apart. The SDK does not report a server mute of a publication already muted
 func (ing *ov3Ingress) lkTrackMuted(pub lksdk.TrackPublication, p lksdk.Participant) {
 	root.logger.Debugw(fmt.Sprintf("lkTrackMuted: %s from %s", pub.SID(), p.Identity()))
-
-	// FIXME: If track belongs to this ingress, close flow to appsink
+	ing.trackMuted(pub.SID(), true)
 }
 
 func (ing *ov3Ingress) lkTrackUnmuted(pub lksdk.TrackPublication, p lksdk.Participant) {
 	root.logger.Debugw(fmt.Sprintf("lkTrackUnmuted: %s from %s", pub.SID(), p.Identity()))
+	ing.trackMuted(pub.SID(), false)
+}
 
-	// FIXME: If tracks belongs to this ingress, reopen flow to the appsink
+func (ing *ov3Ingress) trackMuted(sid string, muted bool) {
+	if trPub := ing.getTrackPublisher(sid); trPub != nil {
+		trPub.remoteMuted(muted)
+	}
 }
 
 func (ing *ov3Ingress) makeRoomIngressConnection(ingressId string, participant string) error {
@@ -68,7 +91,13 @@ func (ing *ov3Ingress) makeRoomIngressConnection(ingressId string, participant s
 		OnReconnected:  ing.lkReconnected,
 	}
 	ing.roomSvc = lksdk.NewRoom(cb)
-	if err := ing.roomSvc.JoinWithToken(room.service.url, room.token, lksdk.WithAutoSubscribe(false)); err != nil {
+	options := []lksdk.ConnectOption{lksdk.WithAutoSubscribe(false)}
+	if interceptors, err := ing.publisherInterceptors(); err != nil {
+		root.logger.Warnw(fmt.Sprintf("makeRoomIngressConnection: no bandwidth estimation for ingress %s", ingressId), err)
+	} else {
+		options = append(options, lksdk.WithInterceptors(interceptors))
+	}
+	if err := ing.roomSvc.JoinWithToken(room.service.url, room.token, options...); err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/livekit/protocol/logger"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// Time to wait before trying again to open the log file once we fell back to stderr
+const logReopenInterval = time.Minute
+
+// Files get older without any rotation, so they are checked against their maximum age from time to time
+const logPruneInterval = 10 * time.Minute
+
+func (cfg *ov3Config) componentLevel(component string) logLevel {
+	if level, ok := cfg.LogLevels[component]; ok {
+		return logLevels[level]
+	}
+	// Components created with WithComponent are named "<component>.<subcomponent>"
+	if i := strings.Index(component, "."); i > 0 {
+		if level, ok := cfg.LogLevels[component[:i]]; ok {
+			return logLevels[level]
+		}
+	}
+	return logLevels[cfg.LogLevel]
+}
+
+// Wraps a LiveKit logger filtering by the level configured for its component, as the logr sink
+// behind it cannot tell warnings from info messages. Levels are read on every call so they can be
+// changed at runtime.
+type leveledLogger struct {
+	logger.Logger
+	component string
+}
+
+func newLeveledLogger(l logger.Logger, component string) logger.Logger {
+	return &leveledLogger{Logger: l, component: component}
+}
+
+func (l *leveledLogger) enabled(level logLevel) bool {
+	return level >= getConfig().componentLevel(l.component)
+}
+
+func (l *leveledLogger) Debugw(msg string, keysAndValues ...any) {
+	if l.enabled(levelDebug) {
+		l.Logger.Debugw(msg, keysAndValues...)
+	}
+}
+
+func (l *leveledLogger) Infow(msg string, keysAndValues ...any) {
+	if l.enabled(levelInfo) {
+		l.Logger.Infow(msg, keysAndValues...)
+	}
+}
+
+func (l *leveledLogger) Warnw(msg string, err error, keysAndValues ...any) {
+	if l.enabled(levelWarn) {
+		l.Logger.Warnw(msg, err, keysAndValues...)
+	}
+}
+
+func (l *leveledLogger) Errorw(msg string, err error, keysAndValues ...any) {
+	if l.enabled(levelError) {
+		l.Logger.Errorw(msg, err, keysAndValues...)
+	}
+}
+
+func (l *leveledLogger) WithValues(keysAndValues ...any) logger.Logger {
+	return newLeveledLogger(l.Logger.WithValues(keysAndValues...), l.component)
+}
+
+func (l *leveledLogger) WithName(name string) logger.Logger {
+	return newLeveledLogger(l.Logger.WithName(name), l.component)
+}
+
+func (l *leveledLogger) WithComponent(component string) logger.Logger {
+	return newLeveledLogger(l.Logger.WithComponent(component), l.component+"."+component)
+}
+
+func (l *leveledLogger) WithCallDepth(depth int) logger.Logger {
+	return newLeveledLogger(l.Logger.WithCallDepth(depth), l.component)
+}
+
+func (l *leveledLogger) WithItemSampler() logger.Logger {
+	return newLeveledLogger(l.Logger.WithItemSampler(), l.component)
+}
+
+func (l *leveledLogger) WithoutSampler() logger.Logger {
+	return newLeveledLogger(l.Logger.WithoutSampler(), l.component)
+}
+
+func (l *leveledLogger) WithDeferredValues() (logger.Logger, logger.DeferredFieldResolver) {
+	deferred, resolver := l.Logger.WithDeferredValues()
+	return newLeveledLogger(deferred, l.component), resolver
+}
+
+// Log file writer creating a new timestamped file when the current one grows beyond the configured
+// size. Files of the same prefix in the log directory, those of earlier runs included, are removed once
+// older than the configured age or beyond the configured count, oldest first. If the log directory is
+// not writable it writes to stderr and retries from time to time.
+type rotatingWriter struct {
+	sync.Mutex
+	dir        string
+	prefix     string
+	file       *os.File
+	size       int64
+	fallbackAt time.Time
+	prunedAt   time.Time
+}
+
+func newRotatingWriter(dir string, prefix string) *rotatingWriter {
+	w := &rotatingWriter{
+		dir:    dir,
+		prefix: prefix,
+	}
+	w.open()
+	w.prune(getConfig())
+	return w
+}
+
+func (w *rotatingWriter) fileName(t time.Time) string {
+	timeSuffix := fmt.Sprintf("%d-%02d-%02dT%02d%02d%02d",
+		t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), t.Second())
+	name := filepath.Join(w.dir, w.prefix+"_"+timeSuffix+".log")
+	// Several rotations within the same second
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return name
+		}
+		name = filepath.Join(w.dir, fmt.Sprintf("%s_%s.%d.log", w.prefix, timeSuffix, i))
+	}
+}
+
+// Must be called with writer lock held
+func (w *rotatingWriter) open() {
+	now := time.Now()
+	name := w.fileName(now)
+	file, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ov3endpoint: cannot open log file %s, logging to stderr: %s\n", name, err.Error())
+		w.file = nil
+		w.fallbackAt = now
+		return
+	}
+	w.file = file
+	w.size = 0
+}
+
+// Must be called with writer lock held
+func (w *rotatingWriter) rotateNeeded(next int, cfg *ov3Config) bool {
+	return (cfg.LogMaxSize > 0) && (w.size+int64(next) > int64(cfg.LogMaxSize)*1024*1024)
+}
+
+// The file in use is never removed, it counts against the maximum number of files.
+// Must be called with writer lock held
+func (w *rotatingWriter) prune(cfg *ov3Config) {
+	w.prunedAt = time.Now()
+	if (cfg.LogMaxFiles == 0) && (cfg.LogMaxAge == 0) {
+		return
+	}
+	names, err := filepath.Glob(filepath.Join(w.dir, w.prefix+"_*.log"))
+	if err != nil {
+		return
+	}
+
+	type logFile struct {
+		name    string
+		modTime time.Time
+	}
+	files := make([]logFile, 0, len(names))
+	for _, name := range names {
+		if (w.file != nil) && (name == w.file.Name()) {
+			continue
+		}
+		info, err := os.Stat(name)
+		if (err != nil) || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, logFile{name: name, modTime: info.ModTime()})
+	}
+	// Newest first
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].modTime.Equal(files[j].modTime) {
+			return files[i].name > files[j].name
+		}
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	keep := len(files)
+	if cfg.LogMaxFiles > 0 {
+		keep = int(cfg.LogMaxFiles)
+		if w.file != nil {
+			keep--
+		}
+	}
+	for i, file := range files {
+		if (i >= keep) || ((cfg.LogMaxAge > 0) && (time.Since(file.modTime) > time.Duration(cfg.LogMaxAge)*time.Hour)) {
+			os.Remove(file.name)
+		}
+	}
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	cfg := getConfig()
+	if w.file == nil {
+		if time.Since(w.fallbackAt) < logReopenInterval {
+			return os.Stderr.Write(p)
+		}
+		w.open()
+		if w.file == nil {
+			return os.Stderr.Write(p)
+		}
+		w.prune(cfg)
+	} else if w.rotateNeeded(len(p), cfg) {
+		w.file.Close()
+		w.open()
+		w.prune(cfg)
+		if w.file == nil {
+			return os.Stderr.Write(p)
+		}
+	} else if time.Since(w.prunedAt) > logPruneInterval {
+		w.prune(cfg)
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err != nil {
+		// Disk full or file removed, keep the message on stderr
+		return os.Stderr.Write(p)
+	}
+	return n, nil
+}
+
+func NewFileLogger(writer io.Writer, json bool) logr.Logger {
+	if json {
+		return funcr.NewJSON(func(obj string) {
+			fmt.Fprintln(writer, obj)
+		}, funcr.Options{
+			Verbosity:       1,
+			LogTimestamp:    true,
+			TimestampFormat: time.RFC3339Nano,
+		})
+	}
+
+	return funcr.New(func(prefix, args string) {
+		t := time.Now()
+		timeSuffix := fmt.Sprintf("%d-%02d-%02d %02d:%02d:%02d.%09d",
+			t.Year(), t.Month(), t.Day(),
+			t.Hour(), t.Minute(), t.Second(), t.Nanosecond())
+		if prefix != "" {
+			fmt.Fprintf(writer, "%s - %s: %s\n", timeSuffix, prefix, args)
+		} else {
+			fmt.Fprintf(writer, "%s - %s\n", timeSuffix, args)
+		}
+	}, funcr.Options{
+		Verbosity: 1,
+	})
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Prometheus text exposition is written by hand, no client library is linked into the Kurento module
+type ov3Metrics struct {
+	server *http.Server
+
+	egressReconnecting  atomic.Uint64
+	egressReconnected   atomic.Uint64
+	ingressReconnecting atomic.Uint64
+	ingressReconnected  atomic.Uint64
+	publishWriteErrors  atomic.Uint64
+}
+
+// Per track counters kept by every AppWriter
+type trackStats struct {
+	packets        atomic.Uint64
+	bytes          atomic.Uint64
+	nackRequests   atomic.Uint64
+	nackDropped    atomic.Uint64
+	plis           atomic.Uint64
+	rtcpPackets    atomic.Uint64
+	pushFlowErrors atomic.Uint64
+	rtxPackets     atomic.Uint64
+}
+
+var metrics ov3Metrics
+
+func (m *ov3Metrics) start() {
+	cfg := getConfig()
+	if cfg.MetricsAddr == "" {
+		return
+	}
+	addr := cfg.MetricsAddr
+	path := cfg.MetricsPath
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w)
+	})
+	m.server = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	go func() {
+		root.logger.Infow(fmt.Sprintf("metrics: listening on %s%s", addr, path))
+		if err := m.server.ListenAndServe(); (err != nil) && (err != http.ErrServerClosed) {
+			root.logger.Errorw(fmt.Sprintf("metrics: listener on %s stopped", addr), err)
+		}
+	}()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type metricLabel struct {
+	name  string
+	value string
+}
+
+type metricSample struct {
+	labels []metricLabel
+	value  uint64
+}
+
+func writeMetricFamily(w io.Writer, name string, kind string, help string, samples ...metricSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, sample := range samples {
+		if len(sample.labels) == 0 {
+			fmt.Fprintf(w, "%s %d\n", name, sample.value)
+			continue
+		}
+		labels := make([]string, 0, len(sample.labels))
+		for _, label := range sample.labels {
+			labels = append(labels, fmt.Sprintf(`%s="%s"`, label.name, labelEscaper.Replace(label.value)))
+		}
+		fmt.Fprintf(w, "%s{%s} %d\n", name, strings.Join(labels, ","), sample.value)
+	}
+}
+
+type rootCounts struct {
+	services      int
+	rooms         int
+	subscriptions int
+	egress        int
+	ingress       int
+	subscribers   int
+	publishers    int
+}
+
+// Same lock discipline as snapshot: root maps are copied first, then each service is locked on its own
+func (rt *ov3Root) counts() rootCounts {
+	rt.RLock()
+	result := rootCounts{
+		services:    len(rt.services),
+		egress:      len(rt.egress),
+		ingress:     len(rt.ingress),
+		subscribers: len(rt.subscribers),
+		publishers:  len(rt.publishers),
+	}
+	services := make([]*ov3Service, 0, len(rt.services))
+	for _, svc := range rt.services {
+		services = append(services, svc)
+	}
+	rt.RUnlock()
+
+	rooms := make([]*ov3Room, 0)
+	for _, svc := range services {
+		svc.RLock()
+		for _, room := range svc.rooms {
+			rooms = append(rooms, room)
+		}
+		svc.RUnlock()
+	}
+	result.rooms = len(rooms)
+	for _, room := range rooms {
+		room.RLock()
+		result.subscriptions += len(room.subscriptions) + len(room.ssSubscriptions)
+		room.RUnlock()
+	}
+
+	return result
+}
+
+func (rt *ov3Root) activeWriters() []*AppWriter {
+	rt.RLock()
+	subscriptions := make(map[*ov3Subscription]bool, len(rt.subscribedTracks))
+	for _, subscription := range rt.subscribedTracks {
+		if subscription != nil {
+			subscriptions[subscription] = true
+		}
+	}
+	rt.RUnlock()
+
+	writers := make([]*AppWriter, 0, 2*len(subscriptions))
+	for subscription := range subscriptions {
+		subscription.RLock()
+		if subscription.audioWriter != nil {
+			writers = append(writers, subscription.audioWriter)
+		}
+		if subscription.videoWriter != nil {
+			writers = append(writers, subscription.videoWriter)
+		}
+		subscription.RUnlock()
+	}
+
+	return writers
+}
+
+func (rt *ov3Root) activeTrackPublishers() []*ov3TrackPublisher {
+	rt.RLock()
+	publishers := make([]*ov3Publisher, 0, len(rt.publishers))
+	for _, pub := range rt.publishers {
+		publishers = append(publishers, pub)
+	}
+	rt.RUnlock()
+
+	trPubs := make([]*ov3TrackPublisher, 0, 2*len(publishers))
+	for _, pub := range publishers {
+		pub.RLock()
+		if pub.audioPublisher != nil {
+			trPubs = append(trPubs, pub.audioPublisher)
+		}
+		if pub.videoPublisher != nil {
+			trPubs = append(trPubs, pub.videoPublisher)
+		}
+		pub.RUnlock()
+	}
+
+	return trPubs
+}
+
+func (w *AppWriter) metricLabels() []metricLabel {
+	return []metricLabel{
+		{"track", w.pub.SID()},
+		{"kind", string(w.kind)},
+		{"codec", string(w.codec)},
+		{"participant", w.subscription.participant},
+	}
+}
+
+func writeTrackMetric(w io.Writer, writers []*AppWriter, name string, help string, value func(*trackStats) uint64) {
+	samples := make([]metricSample, 0, len(writers))
+	for _, writer := range writers {
+		samples = append(samples, metricSample{writer.metricLabels(), value(&writer.stats)})
+	}
+	writeMetricFamily(w, name, "counter", help, samples...)
+}
+
+func writeNackMetric(w io.Writer, writers []*AppWriter, name string, help string, value func(nackStats) uint64) {
+	samples := make([]metricSample, 0, len(writers))
+	for _, writer := range writers {
+		if writer.nack != nil {
+			samples = append(samples, metricSample{writer.metricLabels(), value(writer.nack.snapshot())})
+		}
+	}
+	writeMetricFamily(w, name, "counter", help, samples...)
+}
+
+func writeMetrics(w io.Writer) {
+	counts := root.counts()
+	writeMetricFamily(w, "ov3_services", "gauge", "OpenVidu services with an open connection", metricSample{value: uint64(counts.services)})
+	writeMetricFamily(w, "ov3_rooms", "gauge", "Rooms tracked by the library", metricSample{value: uint64(counts.rooms)})
+	writeMetricFamily(w, "ov3_egress", "gauge", "Egress connections to rooms", metricSample{value: uint64(counts.egress)})
+	writeMetricFamily(w, "ov3_ingress", "gauge", "Ingress participants connected to rooms", metricSample{value: uint64(counts.ingress)})
+	writeMetricFamily(w, "ov3_subscriptions", "gauge", "Subscriptions to remote participants", metricSample{value: uint64(counts.subscriptions)})
+	writeMetricFamily(w, "ov3_subscribers", "gauge", "Kurento subscribers attached to subscriptions", metricSample{value: uint64(counts.subscribers)})
+	writeMetricFamily(w, "ov3_publishers", "gauge", "Kurento publishers", metricSample{value: uint64(counts.publishers)})
+
+	writers := root.activeWriters()
+	writeTrackMetric(w, writers, "ov3_track_packets_total", "RTP packets received on a subscribed track",
+		func(s *trackStats) uint64 { return s.packets.Load() })
+	writeTrackMetric(w, writers, "ov3_track_bytes_total", "RTP bytes received on a subscribed track",
+		func(s *trackStats) uint64 { return s.bytes.Load() })
+	writeTrackMetric(w, writers, "ov3_track_nack_requests_total", "Retransmissions requested by the jitterbuffer",
+		func(s *trackStats) uint64 { return s.nackRequests.Load() })
+	writeTrackMetric(w, writers, "ov3_track_nack_dropped_total", "Retransmission requests discarded because the queue was full",
+		func(s *trackStats) uint64 { return s.nackDropped.Load() })
+	writeTrackMetric(w, writers, "ov3_track_rtx_packets_total", "Retransmitted packets restored into a subscribed track",
+		func(s *trackStats) uint64 { return s.rtxPackets.Load() })
+	writeNackMetric(w, writers, "ov3_track_nack_sent_total", "Sequence numbers sent in NACKs, repeated ones included",
+		func(s nackStats) uint64 { return s.Sent })
+	writeNackMetric(w, writers, "ov3_track_nack_nacked_total", "Missing packets asked for in at least one NACK",
+		func(s nackStats) uint64 { return s.Nacked })
+	writeNackMetric(w, writers, "ov3_track_nack_recovered_total", "NACKed packets that were retransmitted in time",
+		func(s nackStats) uint64 { return s.Recovered })
+	writeNackMetric(w, writers, "ov3_track_nack_late_total", "Missing packets not asked for again because they would arrive after the jitterbuffer deadline",
+		func(s nackStats) uint64 { return s.Late })
+	writeNackMetric(w, writers, "ov3_track_nack_exhausted_total", "Missing packets given up after the maximum number of NACKs",
+		func(s nackStats) uint64 { return s.Exhausted })
+	writeTrackMetric(w, writers, "ov3_track_pli_total", "PLIs sent to the remote publisher",
+		func(s *trackStats) uint64 { return s.plis.Load() })
+	writeTrackMetric(w, writers, "ov3_track_rtcp_packets_total", "RTCP packets forwarded to subscribers",
+		func(s *trackStats) uint64 { return s.rtcpPackets.Load() })
+	writeTrackMetric(w, writers, "ov3_track_push_flow_errors_total", "Buffers not accepted by a subscriber appsrc",
+		func(s *trackStats) uint64 { return s.pushFlowErrors.Load() })
+
+	trPubs := root.activeTrackPublishers()
+	samples := make([]metricSample, 0, len(trPubs))
+	for _, trPub := range trPubs {
+		samples = append(samples, metricSample{
+			[]metricLabel{{"publisher", trPub.publisher.id}, {"kind", string(trPub.kind)}},
+			trPub.writeErrors.Load(),
+		})
+	}
+	writeMetricFamily(w, "ov3_publisher_track_write_errors_total", "counter", "Samples that could not be written to a published track", samples...)
+	writeMetricFamily(w, "ov3_publish_write_errors_total", "counter", "Samples that could not be written to any published track",
+		metricSample{value: metrics.publishWriteErrors.Load()})
+
+	writeMetricFamily(w, "ov3_reconnecting_total", "counter", "Room connections that started reconnecting",
+		metricSample{[]metricLabel{{"role", "egress"}}, metrics.egressReconnecting.Load()},
+		metricSample{[]metricLabel{{"role", "ingress"}}, metrics.ingressReconnecting.Load()})
+	writeMetricFamily(w, "ov3_reconnected_total", "counter", "Room connections successfully reconnected",
+		metricSample{[]metricLabel{{"role", "egress"}}, metrics.egressReconnected.Load()},
+		metricSample{[]metricLabel{{"role", "ingress"}}, metrics.ingressReconnected.Load()})
+}
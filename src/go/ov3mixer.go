@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-gst/go-gst/gst"
+	guuid "github.com/google/uuid"
+	"github.com/livekit/protocol/livekit"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+// Range of the volume element
+const maxMixerGain = 10.0
+
+// Options given by Kurento when creating an audio mixer
+type mixerOptions struct {
+	// Participants to mix, all participants in the room when empty
+	Participants []string `json:"participants"`
+}
+
+// Mixes the microphone tracks of the participants of a room into a single audio bin. Each participant is
+// an ordinary subscriber whose audio bin is decoded and fed into an audiomixer inside the output bin.
+type ov3AudioMixer struct {
+	sync.RWMutex
+	roomParticipants
+	id        string
+	bin       *gst.Bin
+	mixer     *gst.Element
+	inputs    map[string]*mixerInput
+	gains     map[string]float64
+	nextInput int
+}
+
+// Elements feeding one participant into the mixer, the volume applies its gain
+type mixerInput struct {
+	*roomInput
+	volume *gst.Element
+}
+
+func parseMixerOptions(options string) (mixerOptions, error) {
+	result := mixerOptions{}
+
+	if options == "" {
+		return result, nil
+	}
+	if err := json.Unmarshal([]byte(options), &result); err != nil {
+		return result, err
+	}
+	for _, participant := range result.Participants {
+		if participant == "" {
+			return result, errors.New("empty participant in mixer")
+		}
+	}
+
+	return result, nil
+}
+
+func newAudioMixer(room *ov3Room, bin *gst.Bin, options mixerOptions) (*ov3AudioMixer, error) {
+	mixer := &ov3AudioMixer{
+		roomParticipants: newRoomParticipants(room, options.Participants),
+		id:               "GSTMX_" + guuid.New().String(),
+		bin:              bin,
+		inputs:           make(map[string]*mixerInput),
+		gains:            make(map[string]float64),
+	}
+
+	// Names carry the mixer id, several mixers may share the bin
+	audioMixer, err := gst.NewElementWithName("audiomixer", mixer.id+"_audiomixer")
+	if err != nil {
+		return nil, err
+	}
+	// Inputs are live, output starts with the first buffer instead of at running time 0
+	SetArg(audioMixer.Object, "start-time-selection", "first")
+	convert, err := gst.NewElementWithName("audioconvert", mixer.id+"_convert")
+	if err != nil {
+		return nil, err
+	}
+	if err = bin.AddMany(audioMixer, convert); err != nil {
+		return nil, err
+	}
+	if err = audioMixer.Link(convert); err != nil {
+		return nil, err
+	}
+	audioMixer.SyncStateWithParent()
+	convert.SyncStateWithParent()
+	if err = exposeSrcInBin(convert, bin); err != nil {
+		return nil, err
+	}
+	mixer.mixer = audioMixer
+
+	return mixer, nil
+}
+
+// This must be called with mixer lock held
+func (mixer *ov3AudioMixer) createInput(participant string) (*mixerInput, error) {
+	index := mixer.nextInput
+	mixer.nextInput++
+	// Participants may publish Opus or G.711, decodebin picks the decoder
+	input, err := newRoomInput(mixer.id, index, mixer.bin, mixer.mixer, participant, false, []string{"audioconvert", "audioresample", "volume"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := &mixerInput{roomInput: input, volume: input.chain[2]}
+	if gain, ok := mixer.gains[participant]; ok {
+		result.volume.SetProperty("volume", gain)
+	}
+
+	return result, nil
+}
+
+func (mixer *ov3AudioMixer) addParticipant(participant string) {
+	mixer.Lock()
+	defer mixer.Unlock()
+
+	if !mixer.wants(participant) {
+		return
+	}
+	if _, ok := mixer.inputs[participant]; ok {
+		return
+	}
+
+	input, err := mixer.createInput(participant)
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("addParticipant: cannot add participant %s to mixer %s", participant, mixer.id), err)
+		return
+	}
+	input.subscribe(mixer.room, lksdk.TrackKindAudio)
+	mixer.inputs[participant] = input
+
+	root.logger.Debugw(fmt.Sprintf("addParticipant: participant %s added to mixer %s", participant, mixer.id))
+}
+
+func (mixer *ov3AudioMixer) removeParticipant(participant string) {
+	mixer.Lock()
+	defer mixer.Unlock()
+
+	input := mixer.inputs[participant]
+	if input == nil {
+		return
+	}
+	delete(mixer.inputs, participant)
+	input.destroy(mixer.bin, mixer.mixer)
+
+	root.logger.Debugw(fmt.Sprintf("removeParticipant: participant %s removed from mixer %s", participant, mixer.id))
+}
+
+// Gains are kept for participants not yet in the room, so they apply when joining
+func (mixer *ov3AudioMixer) setGain(participant string, gain float64) error {
+	if (gain < 0) || (gain > maxMixerGain) {
+		return fmt.Errorf("gain %f must be between 0 and %.0f", gain, maxMixerGain)
+	}
+
+	mixer.Lock()
+	defer mixer.Unlock()
+
+	mixer.gains[participant] = gain
+	if input := mixer.inputs[participant]; input != nil {
+		return input.volume.SetProperty("volume", gain)
+	}
+	return nil
+}
+
+// Mixes the participants already publishing a microphone, later ones are added when publishing it
+func (mixer *ov3AudioMixer) start() {
+	for _, participant := range mixer.publishing(livekit.TrackSource_MICROPHONE) {
+		mixer.addParticipant(participant)
+	}
+}
+
+func (mixer *ov3AudioMixer) destroy() {
+	mixer.Lock()
+	defer mixer.Unlock()
+
+	for participant, input := range mixer.inputs {
+		input.destroy(mixer.bin, mixer.mixer)
+		delete(mixer.inputs, participant)
+	}
+}
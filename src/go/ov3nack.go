@@ -0,0 +1,258 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+const (
+	// Used until the first RTT sample is available
+	nackInitialRTT = 100 * time.Millisecond
+	nackMinRetry   = 10 * time.Millisecond
+	nackMaxRetry   = 500 * time.Millisecond
+	// Retransmission requests from the jitterbuffer come in bursts, they are sent in a single NACK
+	nackBatchDelay = time.Millisecond
+	// A lost packet is asked for at most this number of times
+	nackMaxAttempts = 3
+	// Packets given up on are not asked for again while the jitterbuffer may still request them, this is
+	// far below the time sequence numbers take to wrap
+	nackGiveUpMemory = 5 * time.Second
+)
+
+// Seconds from the NTP epoch (1900) to the Unix epoch
+const ntpEpochOffset = 2208988800
+
+func toNtpTime(t time.Time) uint64 {
+	nanos := uint64(t.UnixNano())
+	secs := nanos/1000000000 + ntpEpochOffset
+	frac := ((nanos % 1000000000) << 32) / 1000000000
+	return secs<<32 | frac
+}
+
+type nackEntry struct {
+	requested time.Time
+	lastSent  time.Time
+	attempts  int
+}
+
+// Schedules the NACKs of a subscribed track. Missing packets are asked for again each RTT until they
+// arrive, the attempts are exhausted or a retransmission could not arrive before the jitterbuffer gives
+// up on them. Sequence numbers are the ones of the remote track, before continuity munging.
+type nackScheduler struct {
+	sync.Mutex
+	pending    map[uint16]*nackEntry
+	givenUp    map[uint16]time.Time
+	maxPending int
+	rtt        time.Duration
+	rttSamples uint64
+	wake       chan struct{}
+
+	// Counters of the missing packets, sent counts every sequence number in a NACK and nacked only the
+	// first NACK of each
+	requested uint64
+	dropped   uint64
+	sent      uint64
+	nacked    uint64
+	recovered uint64
+	late      uint64
+	exhausted uint64
+}
+
+type nackStats struct {
+	Requested  uint64  `json:"requested"`
+	Dropped    uint64  `json:"dropped"`
+	Sent       uint64  `json:"sent"`
+	Nacked     uint64  `json:"nacked"`
+	Recovered  uint64  `json:"recovered"`
+	Late       uint64  `json:"late"`
+	Exhausted  uint64  `json:"exhausted"`
+	Pending    int     `json:"pending"`
+	RTTMs      int64   `json:"rttMs"`
+	Efficiency float64 `json:"efficiency"`
+}
+
+func newNackScheduler(maxPending int) *nackScheduler {
+	return &nackScheduler{
+		pending:    make(map[uint16]*nackEntry),
+		givenUp:    make(map[uint16]time.Time),
+		maxPending: maxPending,
+		rtt:        nackInitialRTT,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Returns false if there are too many missing packets already, the request is then dropped. Repeated
+// requests keep the time of the first one, which is what the deadline is counted from.
+func (ns *nackScheduler) request(sn uint16, now time.Time) bool {
+	ns.Lock()
+	if _, ok := ns.pending[sn]; ok {
+		ns.Unlock()
+		return true
+	}
+	if since, ok := ns.givenUp[sn]; ok && (now.Sub(since) < nackGiveUpMemory) {
+		ns.Unlock()
+		return true
+	}
+	delete(ns.givenUp, sn)
+	if len(ns.pending) >= ns.maxPending {
+		ns.dropped++
+		ns.Unlock()
+		return false
+	}
+	ns.pending[sn] = &nackEntry{requested: now}
+	ns.requested++
+	ns.Unlock()
+
+	select {
+	case ns.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Called for every packet of the track, a retransmission answering a single NACK gives an RTT sample
+func (ns *nackScheduler) received(sn uint16, now time.Time) {
+	ns.Lock()
+	defer ns.Unlock()
+
+	entry, ok := ns.pending[sn]
+	if !ok {
+		return
+	}
+	delete(ns.pending, sn)
+	if entry.attempts == 0 {
+		// Reordered, it was not asked for yet
+		return
+	}
+	ns.recovered++
+	// Karn: with several NACKs sent there is no way to know which one was answered
+	if entry.attempts == 1 {
+		ns.updateRTT(now.Sub(entry.lastSent))
+	}
+}
+
+// DLRR blocks answered by the SFU to the receiver reference time reports sent by the SDK
+func (ns *nackScheduler) receivedXR(xr *rtcp.ExtendedReport, now time.Time) {
+	nowNTP := uint32(toNtpTime(now) >> 16)
+	for _, block := range xr.Reports {
+		dlrr, ok := block.(*rtcp.DLRRReportBlock)
+		if !ok {
+			continue
+		}
+		for _, report := range dlrr.Reports {
+			if report.LastRR == 0 {
+				continue
+			}
+			// Middle 32 bits of NTP time, 1/65536 seconds
+			delay := nowNTP - report.LastRR - report.DLRR
+			ns.Lock()
+			ns.updateRTT(time.Duration(uint64(delay) * uint64(time.Second) >> 16))
+			ns.Unlock()
+		}
+	}
+}
+
+// This must be called with scheduler lock held
+func (ns *nackScheduler) updateRTT(sample time.Duration) {
+	if (sample <= 0) || (sample > 5*time.Second) {
+		return
+	}
+	if ns.rttSamples == 0 {
+		ns.rtt = sample
+	} else {
+		// Smoothed as TCP does
+		ns.rtt = (7*ns.rtt + sample) / 8
+	}
+	ns.rttSamples++
+}
+
+// This must be called with scheduler lock held
+func (ns *nackScheduler) retryInterval() time.Duration {
+	retry := ns.rtt + ns.rtt/2
+	if retry < nackMinRetry {
+		return nackMinRetry
+	}
+	if retry > nackMaxRetry {
+		return nackMaxRetry
+	}
+	return retry
+}
+
+// Sequence numbers to NACK now and when to check again. Packets that would arrive after the deadline of
+// the jitterbuffer are not asked for.
+func (ns *nackScheduler) due(now time.Time, deadline time.Duration) ([]uint16, time.Duration) {
+	ns.Lock()
+	defer ns.Unlock()
+
+	var seqnums []uint16
+	retry := ns.retryInterval()
+	next := nackMaxRetry
+	for sn, entry := range ns.pending {
+		if entry.attempts > 0 {
+			// Still waiting for the answer to the last NACK
+			if wait := entry.lastSent.Add(retry).Sub(now); wait > 0 {
+				if wait < next {
+					next = wait
+				}
+				continue
+			}
+			if entry.attempts >= nackMaxAttempts {
+				ns.giveUp(sn, now)
+				ns.exhausted++
+				continue
+			}
+		}
+		if now.Add(ns.rtt).Sub(entry.requested) > deadline {
+			ns.giveUp(sn, now)
+			ns.late++
+			continue
+		}
+		if entry.attempts == 0 {
+			ns.nacked++
+		}
+		entry.attempts++
+		entry.lastSent = now
+		seqnums = append(seqnums, sn)
+		if retry < next {
+			next = retry
+		}
+	}
+	ns.sent += uint64(len(seqnums))
+	for sn, since := range ns.givenUp {
+		if now.Sub(since) >= nackGiveUpMemory {
+			delete(ns.givenUp, sn)
+		}
+	}
+
+	return seqnums, next
+}
+
+// This must be called with scheduler lock held
+func (ns *nackScheduler) giveUp(sn uint16, now time.Time) {
+	delete(ns.pending, sn)
+	ns.givenUp[sn] = now
+}
+
+func (ns *nackScheduler) snapshot() nackStats {
+	ns.Lock()
+	defer ns.Unlock()
+
+	result := nackStats{
+		Requested: ns.requested,
+		Dropped:   ns.dropped,
+		Sent:      ns.sent,
+		Nacked:    ns.nacked,
+		Recovered: ns.recovered,
+		Late:      ns.late,
+		Exhausted: ns.exhausted,
+		Pending:   len(ns.pending),
+		RTTMs:     ns.rtt.Milliseconds(),
+	}
+	// Share of the packets NACKed that were recovered, whatever the number of NACKs each one took
+	if ns.nacked > 0 {
+		result.Efficiency = float64(ns.recovered) / float64(ns.nacked)
+	}
+	return result
+}
@@ -23,6 +23,34 @@ func (pub *ov3Publisher) removeTrackPublisher(trPub *ov3TrackPublisher) {
 	trPub.DestroyTrackPublisher()
 }
 
+func (pub *ov3Publisher) setMuted(kind lksdk.TrackKind, muted bool) error {
+	var trPub *ov3TrackPublisher
+
+	switch kind {
+	case lksdk.TrackKindAudio:
+		trPub = pub.audioPublisher
+	case lksdk.TrackKindVideo:
+		trPub = pub.videoPublisher
+	default:
+		return fmt.Errorf("invalid track kind %s", kind)
+	}
+	if trPub == nil {
+		return fmt.Errorf("no %s track published", kind)
+	}
+
+	trPub.setMuted(muted)
+	return nil
+}
+
+// The input stops on purpose, stall detection is suspended meanwhile
+func (pub *ov3Publisher) setIdle(idle bool) {
+	for _, trPub := range []*ov3TrackPublisher{pub.audioPublisher, pub.videoPublisher} {
+		if trPub != nil {
+			trPub.idle.Store(idle)
+		}
+	}
+}
+
 func exposeSinkInBin(element *gst.Element, bin *gst.Bin) error {
 	pad := element.GetStaticPad("sink")
 	if pad == nil {
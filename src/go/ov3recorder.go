@@ -0,0 +1,608 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	guuid "github.com/google/uuid"
+	"github.com/livekit/egress/pkg/types"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+const (
+	recordingActive    = "recording"
+	recordingStopping  = "stopping"
+	recordingCompleted = "completed"
+	recordingFailed    = "failed"
+)
+
+const (
+	defaultRecordingDirectory = "/tmp"
+	// Time given to the muxer to write the file trailer once the stream is finished
+	recordingStopTimeout = 10 * time.Second
+	recordingBusPoll     = 500 * time.Millisecond
+	// A track may end while its participant stays, a camera toggled for instance. The recording is only
+	// stopped if no new track of that kind is subscribed within this time
+	recordingTrackGrace = 10 * time.Second
+)
+
+// HLS segment length in seconds and number of segments in the playlist
+const (
+	defaultHlsSegmentDuration = 6
+	defaultHlsPlaylistLength  = 5
+	maxHlsSegmentDuration     = 60
+)
+
+// AAC encoders tried in order for HLS audio, players do not support Opus on MPEG-TS
+var hlsAudioEncoders = []string{"fdkaacenc", "avenc_aac", "voaacenc"}
+
+// Container written by splitmuxsink and the codecs it can hold. HLS is written by hlssink2 instead, as
+// segments and a playlist, and audio is transcoded to AAC
+type recordingFormat struct {
+	muxer     string
+	extension string
+	codecs    map[types.MimeType]bool
+	hls       bool
+}
+
+var recordingFormats = map[string]recordingFormat{
+	"mp4": {
+		muxer:     "mp4mux",
+		extension: "mp4",
+		codecs:    map[types.MimeType]bool{types.MimeTypeOpus: true, types.MimeTypeH264: true, types.MimeTypeVP9: true},
+	},
+	"webm": {
+		muxer:     "webmmux",
+		extension: "webm",
+		codecs:    map[types.MimeType]bool{types.MimeTypeOpus: true, types.MimeTypeVP8: true, types.MimeTypeVP9: true},
+	},
+	"mkv": {
+		muxer:     "matroskamux",
+		extension: "mkv",
+		codecs:    map[types.MimeType]bool{types.MimeTypeOpus: true, types.MimeTypeH264: true, types.MimeTypeVP8: true, types.MimeTypeVP9: true},
+	},
+	"hls": {
+		muxer:     "mpegtsmux",
+		extension: "ts",
+		codecs:    map[types.MimeType]bool{types.MimeTypeOpus: true, types.MimeTypeH264: true},
+		hls:       true,
+	},
+}
+
+// Codec of the depayloaded stream exposed by a subscriber bin, and the parser needed before muxing
+var recordingDepayloaders = map[string]types.MimeType{
+	"rtpopusdepay": types.MimeTypeOpus,
+	"rtppcmudepay": mimeTypePCMU,
+	"rtppcmadepay": mimeTypePCMA,
+	"rtph264depay": types.MimeTypeH264,
+	"rtpvp8depay":  types.MimeTypeVP8,
+	"rtpvp9depay":  types.MimeTypeVP9,
+}
+
+// Audio the container cannot hold is decoded and encoded again, as Opus or as AAC for HLS
+var recordingAudioDecoders = map[types.MimeType]string{
+	types.MimeTypeOpus: "opusdec",
+	mimeTypePCMU:       "mulawdec",
+	mimeTypePCMA:       "alawdec",
+}
+
+var recordingParsers = map[types.MimeType]string{
+	types.MimeTypeOpus: "opusparse",
+	types.MimeTypeH264: "h264parse",
+	types.MimeTypeVP9:  "vp9parse",
+}
+
+// Options given by Kurento when starting a recording
+type recordingOptions struct {
+	Format    string `json:"format"`
+	Directory string `json:"directory"`
+	Audio     bool   `json:"audio"`
+	Video     bool   `json:"video"`
+	// Seconds per file, 0 only splits when requested
+	MaxDuration uint `json:"maxDuration"`
+	// HLS only. hlssink2 writes whole segments, so latency is bounded by the segment duration
+	SegmentDuration uint `json:"segmentDuration"`
+	PlaylistLength  uint `json:"playlistLength"`
+	// Low latency HLS needs partial segments, which hlssink2 cannot write, so it is rejected
+	LowLatency bool `json:"lowLatency"`
+}
+
+func parseRecordingOptions(options string) (recordingOptions, error) {
+	result := recordingOptions{
+		Format:    "mp4",
+		Directory: defaultRecordingDirectory,
+		Audio:     true,
+		Video:     true,
+	}
+
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &result); err != nil {
+			return result, err
+		}
+	}
+	if _, ok := recordingFormats[result.Format]; !ok {
+		return result, fmt.Errorf("unknown recording format %s", result.Format)
+	}
+	if result.Directory == "" {
+		return result, errors.New("empty recording directory")
+	}
+	if !result.Audio && !result.Video {
+		return result, errors.New("recording needs audio, video or both")
+	}
+	if recordingFormats[result.Format].hls {
+		if result.SegmentDuration == 0 {
+			result.SegmentDuration = defaultHlsSegmentDuration
+		}
+		if result.PlaylistLength == 0 {
+			result.PlaylistLength = defaultHlsPlaylistLength
+		}
+		if result.SegmentDuration > maxHlsSegmentDuration {
+			return result, fmt.Errorf("segment duration %d s exceeds %d s", result.SegmentDuration, maxHlsSegmentDuration)
+		}
+	}
+	if result.LowLatency {
+		return result, errors.New("low latency HLS is not supported, hlssink2 writes no partial segments")
+	}
+
+	return result, nil
+}
+
+// Records the tracks of a participant into local files. The recorder is an ordinary subscriber whose bins
+// live in a pipeline of its own, so the depayloaded media comes from the same writer and jitterbuffer path
+// used for Kurento, and it is muxed by splitmuxsink, or hlssink2 for HLS, which finalize files on EOS.
+// Finished recordings are kept until their completion status is read or they are released.
+type ov3Recorder struct {
+	sync.Mutex
+	id          string
+	room        *ov3Room
+	participant string
+	screenShare bool
+	options     recordingOptions
+	format      recordingFormat
+	pipeline    *gst.Pipeline
+	sink        *gst.Element
+	audioBin    *gst.Bin
+	videoBin    *gst.Bin
+	subscriber  *ov3Subscriber
+	linked      map[lksdk.TrackKind]types.MimeType
+	ended       map[lksdk.TrackKind]*time.Timer
+	state       string
+	files       []string
+	err         string
+	startTime   time.Time
+	endTime     time.Time
+	done        chan struct{}
+}
+
+func newRecorder(room *ov3Room, participant string, screenShare bool, options recordingOptions) (*ov3Recorder, error) {
+	var err error
+
+	recorder := &ov3Recorder{
+		id:          "GSTRC_" + guuid.New().String(),
+		room:        room,
+		participant: participant,
+		screenShare: screenShare,
+		options:     options,
+		format:      recordingFormats[options.Format],
+		linked:      make(map[lksdk.TrackKind]types.MimeType),
+		ended:       make(map[lksdk.TrackKind]*time.Timer),
+		done:        make(chan struct{}),
+	}
+
+	if recorder.pipeline, err = gst.NewPipeline(recorder.id); err != nil {
+		return nil, err
+	}
+	if recorder.format.hls {
+		err = recorder.createHlsSink()
+	} else {
+		err = recorder.createFileSink()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = recorder.pipeline.Add(recorder.sink); err != nil {
+		return nil, err
+	}
+
+	if options.Audio {
+		recorder.audioBin = gst.NewBin("recording_audio")
+		if err = recorder.pipeline.Add(recorder.audioBin.Element); err != nil {
+			return nil, err
+		}
+		recorder.audioBin.Connect("pad-added", func(element *gst.Element, pad *gst.Pad) {
+			recorder.linkTrack(lksdk.TrackKindAudio, recorder.audioBin, pad)
+		})
+	}
+	if options.Video {
+		recorder.videoBin = gst.NewBin("recording_video")
+		if err = recorder.pipeline.Add(recorder.videoBin.Element); err != nil {
+			return nil, err
+		}
+		recorder.videoBin.Connect("pad-added", func(element *gst.Element, pad *gst.Pad) {
+			recorder.linkTrack(lksdk.TrackKindVideo, recorder.videoBin, pad)
+		})
+	}
+
+	return recorder, nil
+}
+
+func (recorder *ov3Recorder) createFileSink() error {
+	var err error
+
+	if recorder.sink, err = gst.NewElementWithName("splitmuxsink", "recording_sink"); err != nil {
+		return err
+	}
+	location := filepath.Join(recorder.options.Directory, fmt.Sprintf("%s_%%05d.%s", recorder.id, recorder.format.extension))
+	recorder.sink.SetProperty("location", location)
+	recorder.sink.SetProperty("muxer-factory", recorder.format.muxer)
+	if recorder.options.MaxDuration > 0 {
+		recorder.sink.SetProperty("max-size-time", uint64(time.Duration(recorder.options.MaxDuration)*time.Second))
+	}
+	return nil
+}
+
+// Segments and playlist go to a directory named after the recording. hlssink2 asks upstream for key
+// frames at each segment boundary, which the subscriber turns into PLIs
+func (recorder *ov3Recorder) createHlsSink() error {
+	var err error
+
+	directory := filepath.Join(recorder.options.Directory, recorder.id)
+	if err = os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+	if recorder.sink, err = gst.NewElementWithName("hlssink2", "recording_sink"); err != nil {
+		return err
+	}
+	playlist := filepath.Join(directory, "playlist.m3u8")
+	recorder.sink.SetProperty("location", filepath.Join(directory, fmt.Sprintf("segment_%%05d.%s", recorder.format.extension)))
+	recorder.sink.SetProperty("playlist-location", playlist)
+	recorder.sink.SetProperty("target-duration", recorder.options.SegmentDuration)
+	recorder.sink.SetProperty("playlist-length", recorder.options.PlaylistLength)
+	// Segments out of the window are deleted, keeping a couple for players still downloading them
+	recorder.sink.SetProperty("max-files", recorder.options.PlaylistLength+2)
+	recorder.files = []string{playlist}
+
+	return nil
+}
+
+// Elements between a subscriber bin and the muxer, a parser when the muxer needs one or a transcoding chain
+// when it cannot hold the audio codec
+func (recorder *ov3Recorder) chainFor(codec types.MimeType) ([]string, error) {
+	decoder, audio := recordingAudioDecoders[codec]
+	if audio && recorder.format.hls {
+		for _, encoder := range hlsAudioEncoders {
+			if gst.Find(encoder) != nil {
+				return []string{decoder, "audioconvert", "audioresample", encoder}, nil
+			}
+		}
+		return nil, errors.New("no AAC encoder available for HLS audio")
+	}
+	if audio && !recorder.format.codecs[codec] {
+		return []string{decoder, "audioconvert", "audioresample", "opusenc"}, nil
+	}
+	if parser, ok := recordingParsers[codec]; ok {
+		return []string{parser}, nil
+	}
+	return nil, nil
+}
+
+// This must be called with recorder lock held
+func (recorder *ov3Recorder) addChain(kind lksdk.TrackKind, factories []string) (*gst.Element, *gst.Element, error) {
+	elements := make([]*gst.Element, 0, len(factories))
+	for i, factory := range factories {
+		element, err := gst.NewElementWithName(factory, fmt.Sprintf("recording_%s_%d", kind, i))
+		if err != nil {
+			return nil, nil, err
+		}
+		elements = append(elements, element)
+	}
+	if err := recorder.pipeline.AddMany(elements...); err != nil {
+		return nil, nil, err
+	}
+	if len(elements) > 1 {
+		if err := gst.ElementLinkMany(elements...); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, element := range elements {
+		element.SyncStateWithParent()
+	}
+	return elements[0], elements[len(elements)-1], nil
+}
+
+// Called from pad-added of the subscriber bins once the track is subscribed, the codec is taken from the
+// depayloader the subscriber has chosen for the track
+func (recorder *ov3Recorder) linkTrack(kind lksdk.TrackKind, bin *gst.Bin, pad *gst.Pad) {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	if recorder.state != recordingActive {
+		return
+	}
+	if _, ok := recorder.linked[kind]; ok {
+		root.logger.Infow(fmt.Sprintf("linkTrack: %s track already recorded on %s, ignoring new track", kind, recorder.id))
+		return
+	}
+
+	depayloader, _ := bin.GetElementByName(fmt.Sprintf("depayloader_%s", kind))
+	if depayloader == nil {
+		root.logger.Infow(fmt.Sprintf("linkTrack: no %s depayloader on recording %s", kind, recorder.id))
+		return
+	}
+	codec, ok := recordingDepayloaders[depayloader.GetFactory().GetName()]
+	_, transcoded := recordingAudioDecoders[codec]
+	if !ok || (!recorder.format.codecs[codec] && !transcoded) {
+		recorder.failLocked(fmt.Sprintf("%s codec %s cannot be recorded as %s", kind, codec, recorder.options.Format))
+		return
+	}
+
+	src := pad
+	factories, err := recorder.chainFor(codec)
+	if err != nil {
+		recorder.failLocked(err.Error())
+		return
+	}
+	if len(factories) > 0 {
+		first, last, err := recorder.addChain(kind, factories)
+		if err != nil {
+			recorder.failLocked(err.Error())
+			return
+		}
+		if ret := pad.Link(first.GetStaticPad("sink")); ret != gst.PadLinkOK {
+			recorder.failLocked(fmt.Sprintf("cannot link %s track into %s: %s", kind, factories[0], ret.String()))
+			return
+		}
+		src = last.GetStaticPad("src")
+	}
+
+	var sinkPad *gst.Pad
+	if kind == lksdk.TrackKindVideo {
+		sinkPad = recorder.sink.GetRequestPad("video")
+	} else if recorder.format.hls {
+		sinkPad = recorder.sink.GetRequestPad("audio")
+	} else {
+		sinkPad = recorder.sink.GetRequestPad("audio_%u")
+	}
+	if sinkPad == nil {
+		recorder.failLocked(fmt.Sprintf("cannot get %s pad from splitmuxsink", kind))
+		return
+	}
+	if ret := src.Link(sinkPad); ret != gst.PadLinkOK {
+		recorder.failLocked(fmt.Sprintf("cannot link %s track into muxer: %s", kind, ret.String()))
+		return
+	}
+	recorder.linked[kind] = codec
+
+	root.logger.Debugw(fmt.Sprintf("linkTrack: recording %s %s track of %s on %s", codec, kind, recorder.participant, recorder.id))
+}
+
+func (recorder *ov3Recorder) start() error {
+	if ret := recorder.pipeline.SetState(gst.StatePlaying); ret != nil {
+		return ret
+	}
+	recorder.Lock()
+	recorder.state = recordingActive
+	recorder.startTime = time.Now()
+	recorder.Unlock()
+
+	go recorder.watchBus()
+
+	// Tracks already subscribed are linked right away from pad-added, so the recorder lock is not held here
+	subscriber := recorder.room.addSubscriber(recorder.participant, recorder.screenShare, recorder.audioBin, recorder.videoBin, defaultSubscriberOptions())
+	root.addSubscriber(subscriber.id, subscriber)
+
+	recorder.Lock()
+	finished := (recorder.state == recordingCompleted) || (recorder.state == recordingFailed)
+	if !finished {
+		recorder.subscriber = subscriber
+	}
+	recorder.Unlock()
+	if finished {
+		unsubscribeParticipantImpl(subscriber.id)
+	}
+
+	return nil
+}
+
+// Splitting happens at the next key frame, which is requested to the publisher straight away
+func (recorder *ov3Recorder) split() error {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	if recorder.state != recordingActive {
+		return fmt.Errorf("recording %s is %s", recorder.id, recorder.state)
+	}
+	if recorder.format.hls {
+		return fmt.Errorf("recording %s is split in HLS segments", recorder.id)
+	}
+	if _, err := recorder.sink.Emit("split-now"); err != nil {
+		return err
+	}
+	if recorder.subscriber != nil {
+		go requestKeyFrameImpl(recorder.subscriber.id)
+	}
+	return nil
+}
+
+// Sends EOS through the subscriber bins so the muxer writes the file trailer, the recording is finished
+// from the bus once splitmuxsink has closed the file
+func (recorder *ov3Recorder) stop() error {
+	recorder.Lock()
+	if recorder.state != recordingActive {
+		state := recorder.state
+		recorder.Unlock()
+		if state == recordingStopping {
+			return nil
+		}
+		return fmt.Errorf("recording %s is %s", recorder.id, state)
+	}
+	recorder.state = recordingStopping
+	nothingLinked := len(recorder.linked) == 0
+	recorder.Unlock()
+
+	if nothingLinked {
+		recorder.finish("no media was recorded")
+		return nil
+	}
+
+	root.logger.Debugw(fmt.Sprintf("stop: finalizing recording %s", recorder.id))
+	recorder.pipeline.SendEvent(gst.NewEOSEvent())
+	time.AfterFunc(recordingStopTimeout, func() {
+		recorder.finish("timeout finalizing recording")
+	})
+	return nil
+}
+
+// The remote track is gone, the file is finalized unless the participant publishes a new one in time
+func (recorder *ov3Recorder) trackEnded(participant string, screenShare bool, kind lksdk.TrackKind) {
+	if (participant != recorder.participant) || (screenShare != recorder.screenShare) {
+		return
+	}
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	if _, recorded := recorder.linked[kind]; !recorded {
+		return
+	}
+	if recorder.ended == nil {
+		recorder.ended = make(map[lksdk.TrackKind]*time.Timer)
+	}
+	if recorder.ended[kind] != nil {
+		return
+	}
+
+	root.logger.Debugw(fmt.Sprintf("trackEnded: %s track of %s ended, recording %s waits for a new one", kind, participant, recorder.id))
+	var timer *time.Timer
+	timer = time.AfterFunc(recordingTrackGrace, func() {
+		recorder.Lock()
+		replaced := recorder.ended[kind] != timer
+		delete(recorder.ended, kind)
+		recorder.Unlock()
+		if replaced {
+			return
+		}
+		root.logger.Debugw(fmt.Sprintf("trackEnded: no new %s track of %s, stopping recording %s", kind, participant, recorder.id))
+		if err := recorder.stop(); err != nil {
+			root.logger.Debugw(fmt.Sprintf("trackEnded: %s", err.Error()))
+		}
+	})
+	recorder.ended[kind] = timer
+}
+
+// A new track of the participant keeps being written to the same file
+func (recorder *ov3Recorder) trackStarted(participant string, screenShare bool, kind lksdk.TrackKind) {
+	if (participant != recorder.participant) || (screenShare != recorder.screenShare) {
+		return
+	}
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	if timer := recorder.ended[kind]; timer != nil {
+		timer.Stop()
+		delete(recorder.ended, kind)
+		root.logger.Debugw(fmt.Sprintf("trackStarted: %s track of %s replaced, recording %s goes on", kind, participant, recorder.id))
+	}
+}
+
+// Nothing else will be published by the participant, so the file is finalized right away
+func (recorder *ov3Recorder) participantLeft(participant string) {
+	if participant != recorder.participant {
+		return
+	}
+	root.logger.Debugw(fmt.Sprintf("participantLeft: %s left, stopping recording %s", participant, recorder.id))
+	if err := recorder.stop(); err != nil {
+		root.logger.Debugw(fmt.Sprintf("participantLeft: %s", err.Error()))
+	}
+}
+
+func (recorder *ov3Recorder) watchBus() {
+	bus := recorder.pipeline.GetPipelineBus()
+
+	for {
+		select {
+		case <-recorder.done:
+			return
+		default:
+		}
+
+		msg := bus.TimedPop(gst.ClockTime(recordingBusPoll))
+		if msg == nil {
+			continue
+		}
+		switch msg.Type() {
+		case gst.MessageEOS:
+			recorder.finish("")
+		case gst.MessageError:
+			recorder.finish(msg.ParseError().Error())
+		case gst.MessageElement:
+			recorder.handleSinkMessage(msg.GetStructure())
+		}
+	}
+}
+
+// Only files written by splitmuxsink are listed, HLS segments are listed in the playlist
+func (recorder *ov3Recorder) handleSinkMessage(structure *gst.Structure) {
+	if recorder.format.hls || (structure == nil) || (structure.Name() != "splitmuxsink-fragment-opened") {
+		return
+	}
+	location, err := structure.GetValue("location")
+	if err != nil {
+		return
+	}
+	if file, ok := location.(string); ok {
+		recorder.Lock()
+		recorder.files = append(recorder.files, file)
+		recorder.Unlock()
+		root.logger.Debugw(fmt.Sprintf("handleSinkMessage: recording %s writing %s", recorder.id, file))
+	}
+}
+
+// This must be called with recorder lock held, the pipeline is torn down out of the caller thread since
+// this can be reached from streaming threads
+func (recorder *ov3Recorder) failLocked(reason string) {
+	root.logger.Infow(fmt.Sprintf("recording %s failed: %s", recorder.id, reason))
+	if recorder.err == "" {
+		recorder.err = reason
+	}
+	go recorder.finish(reason)
+}
+
+func (recorder *ov3Recorder) finish(reason string) {
+	recorder.Lock()
+	if (recorder.state == recordingCompleted) || (recorder.state == recordingFailed) {
+		recorder.Unlock()
+		return
+	}
+	if reason == "" {
+		recorder.state = recordingCompleted
+	} else {
+		recorder.state = recordingFailed
+		if recorder.err == "" {
+			recorder.err = reason
+		}
+	}
+	recorder.endTime = time.Now()
+	for kind, timer := range recorder.ended {
+		timer.Stop()
+		delete(recorder.ended, kind)
+	}
+	state := recorder.state
+	subscriber := recorder.subscriber
+	recorder.subscriber = nil
+	close(recorder.done)
+	recorder.Unlock()
+
+	if subscriber != nil {
+		unsubscribeParticipantImpl(subscriber.id)
+	}
+	recorder.pipeline.SetState(gst.StateNull)
+	recorder.room.deleteRecorder(recorder.id)
+
+	root.logger.Infow(fmt.Sprintf("finish: recording %s %s", recorder.id, state))
+}
@@ -22,6 +22,14 @@ type ov3Room struct {
 	ssSubscriptions map[string]*ov3Subscription
 	connected       bool
 
+	// When aligned, subscribers of this egress share the room timeline instead of having their own
+	aligned  bool
+	timeline syncBase
+
+	mixers     map[string]*ov3AudioMixer
+	composites map[string]*ov3VideoComposite
+	recorders  map[string]*ov3Recorder
+
 	roomClient *lksdk.RoomServiceClient
 
 	ingress map[string]*ov3Ingress
@@ -74,6 +82,20 @@ func (room *ov3Room) removeSubscription(participantId string, screenShare bool)
 	}
 }
 
+// Moves the subscribers already created on the egress to the room timeline.
+// This must be called with room lock held
+func (room *ov3Room) alignSubscribers() {
+	for _, subscriptions := range []map[string]*ov3Subscription{room.subscriptions, room.ssSubscriptions} {
+		for _, subs := range subscriptions {
+			subs.Lock()
+			for _, subscriber := range subs.subscribers {
+				subscriber.setTimeline(&room.timeline)
+			}
+			subs.Unlock()
+		}
+	}
+}
+
 func (room *ov3Room) connectServiceClient() {
 	if room.roomClient == nil {
 		room.roomClient = lksdk.NewRoomServiceClient(room.service.url, room.service.key, room.service.secret)
@@ -112,16 +134,103 @@ func (room *ov3Room) getIngressByParticipant(partcipantId string) *ov3Ingress {
 	return result
 }
 
-func (room *ov3Room) addSubscriber(participantId string, screenShare bool, audioSource *gst.Bin, videoSource *gst.Bin) *ov3Subscriber {
+func (room *ov3Room) addMixer(mixer *ov3AudioMixer) {
+	room.Lock()
+	defer room.Unlock()
+
+	if room.mixers == nil {
+		room.mixers = make(map[string]*ov3AudioMixer)
+	}
+	room.mixers[mixer.id] = mixer
+}
+
+func (room *ov3Room) deleteMixer(mixerId string) {
+	room.Lock()
+	defer room.Unlock()
+
+	delete(room.mixers, mixerId)
+}
+
+// Mixers are called without room lock held, as they add and remove subscribers
+func (room *ov3Room) getMixers() []*ov3AudioMixer {
+	room.RLock()
+	defer room.RUnlock()
+
+	result := make([]*ov3AudioMixer, 0, len(room.mixers))
+	for _, mixer := range room.mixers {
+		result = append(result, mixer)
+	}
+	return result
+}
+
+func (room *ov3Room) addComposite(composite *ov3VideoComposite) {
+	room.Lock()
+	defer room.Unlock()
+
+	if room.composites == nil {
+		room.composites = make(map[string]*ov3VideoComposite)
+	}
+	room.composites[composite.id] = composite
+}
+
+func (room *ov3Room) deleteComposite(compositeId string) {
+	room.Lock()
+	defer room.Unlock()
+
+	delete(room.composites, compositeId)
+}
+
+// Composites are called without room lock held, as they add and remove subscribers
+func (room *ov3Room) getComposites() []*ov3VideoComposite {
+	room.RLock()
+	defer room.RUnlock()
+
+	result := make([]*ov3VideoComposite, 0, len(room.composites))
+	for _, composite := range room.composites {
+		result = append(result, composite)
+	}
+	return result
+}
+
+func (room *ov3Room) addRecorder(recorder *ov3Recorder) {
+	room.Lock()
+	defer room.Unlock()
+
+	if room.recorders == nil {
+		room.recorders = make(map[string]*ov3Recorder)
+	}
+	room.recorders[recorder.id] = recorder
+}
+
+func (room *ov3Room) deleteRecorder(recorderId string) {
+	room.Lock()
+	defer room.Unlock()
+
+	delete(room.recorders, recorderId)
+}
+
+// Recorders are called without room lock held, as they add and remove subscribers
+func (room *ov3Room) getRecorders() []*ov3Recorder {
+	room.RLock()
+	defer room.RUnlock()
+
+	result := make([]*ov3Recorder, 0, len(room.recorders))
+	for _, recorder := range room.recorders {
+		result = append(result, recorder)
+	}
+	return result
+}
+
+func (room *ov3Room) addSubscriber(participantId string, screenShare bool, audioSource *gst.Bin, videoSource *gst.Bin, options subscriberOptions) *ov3Subscriber {
 	room.Lock()
 	subscription := room.getSubscription(participantId, screenShare)
 	if subscription == nil {
 		subscription = room.addSubscription(participantId, screenShare)
-		subscription.makeSubscription()
-		subscription.subscribeToParticipant()
 	}
 
-	subscriber := subscription.addSubscriber(audioSource, videoSource)
+	subscriber := subscription.addSubscriber(audioSource, videoSource, options)
+	// Only kinds some subscriber has a bin for are subscribed, the new one may want a kind the others did not
+	subscription.makeSubscription()
 	room.Unlock()
 	subscription.buildSubscriber(subscriber)
 
@@ -163,8 +272,6 @@ func (lk *ov3Room) lkParticipantConnected(participant *lksdk.RemoteParticipant)
 	// Check if some pending subscription, perhaps track published has arrived before
 	// this event
 	lk.Lock()
-	defer lk.Unlock()
-
 	subs := lk.getSubscription(participant.Identity(), false)
 	if subs != nil {
 		lk.checkTrackSubscription(subs, participant)
@@ -173,18 +280,40 @@ func (lk *ov3Room) lkParticipantConnected(participant *lksdk.RemoteParticipant)
 	if subsSS != nil {
 		lk.checkTrackSubscription(subsSS, participant)
 	}
+	lk.Unlock()
+
+	if participant.GetTrackPublication(livekit.TrackSource_MICROPHONE) != nil {
+		for _, mixer := range lk.getMixers() {
+			mixer.addParticipant(participant.Identity())
+		}
+	}
+	for _, composite := range lk.getComposites() {
+		composite.addParticipant(participant.Identity())
+	}
 }
 
 func (lk *ov3Room) lkParticipantDisconnected(participant *lksdk.RemoteParticipant) {
 	root.logger.Debugw(fmt.Sprintf("lkParticipantDisconnected: %s", participant.Identity()))
+
+	for _, mixer := range lk.getMixers() {
+		mixer.removeParticipant(participant.Identity())
+	}
+	for _, composite := range lk.getComposites() {
+		composite.removeParticipant(participant.Identity())
+	}
+	for _, recorder := range lk.getRecorders() {
+		recorder.participantLeft(participant.Identity())
+	}
 }
 
 func (room *ov3Room) lkReconnecting() {
 	root.logger.Debugw("lkReconnecting")
+	metrics.egressReconnecting.Add(1)
 }
 
 func (room *ov3Room) lkReconnected() {
 	root.logger.Debugw("lkReconnected")
+	metrics.egressReconnected.Add(1)
 }
 
 func (room *ov3Room) lkDisconnected() {
@@ -208,9 +337,19 @@ func (room *ov3Room) lkDisconnected() {
 }
 
 func (lk *ov3Room) lkActiveSpeakersChanged(participants []lksdk.Participant) {
-	// No need yet to do anything here
-	// FIXME: implement this as it may help to organize possible MCUs layout
 	root.logger.Debugw("lkActiveSpeakersChanged")
+
+	composites := lk.getComposites()
+	if len(composites) == 0 {
+		return
+	}
+	speakers := make([]string, 0, len(participants))
+	for _, p := range participants {
+		speakers = append(speakers, p.Identity())
+	}
+	for _, composite := range composites {
+		composite.setSpeakers(speakers)
+	}
 }
 
 func (lk *ov3Room) lkTrackMuted(pub lksdk.TrackPublication, p lksdk.Participant) {
@@ -244,14 +383,15 @@ func (lk *ov3Room) lkTrackUnmuted(pub lksdk.TrackPublication, p lksdk.Participan
 	}
 	audioTrack := subscription.audioTrack
 	videoTrack := subscription.videoTrack
-	if audioTrack != nil {
-		if audioTrack.trackId == pub.SID() {
-			subscription.audioWriter.SetTrackMuted(false)
+	if (audioTrack != nil) && (audioTrack.trackId == pub.SID()) {
+		w := subscription.audioWriter
+		if w != nil {
+			w.SetTrackMuted(false)
 		}
-	}
-	if videoTrack != nil {
-		if videoTrack.trackId == pub.SID() {
-			subscription.videoWriter.SetTrackMuted(false)
+	} else if (videoTrack != nil) && (videoTrack.trackId == pub.SID()) {
+		w := subscription.videoWriter
+		if w != nil {
+			w.SetTrackMuted(false)
 		}
 	}
 }
@@ -294,6 +434,12 @@ func (lk *ov3Room) lkTrackSubscribed(track *webrtc.TrackRemote, pub *lksdk.Remot
 		}
 	}
 	subscription.Unlock()
+
+	if err == nil {
+		for _, recorder := range lk.getRecorders() {
+			recorder.trackStarted(rp.Identity(), subscription.isScreenShare, pub.Kind())
+		}
+	}
 }
 
 func (lk *ov3Room) lkTrackUnsubscribed(track *webrtc.TrackRemote, pub *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) {
@@ -305,29 +451,24 @@ func (lk *ov3Room) lkTrackUnsubscribed(track *webrtc.TrackRemote, pub *lksdk.Rem
 	}
 	root.logger.Debugw(fmt.Sprintf("lkTrackUnsubscribed: marking as unsubscribed  %s from %s", pub.SID(), rp.Identity()))
 	subscription.Lock()
+	// The track may already have been replaced by a new one of the participant, that one is kept
 	if pub.Kind() == "audio" {
 		audioTrack := subscription.audioTrack
-		if audioTrack != nil {
+		if (audioTrack != nil) && (audioTrack.trackId == pub.SID()) {
 			audioTrack.subscribed = false
 		}
-		writer := subscription.audioWriter
-		if writer != nil {
-			writer.endStream.Break()
-			subscription.audioWriter = nil
-		}
 	} else if pub.Kind() == "video" {
 		videoTrack := subscription.videoTrack
-		if videoTrack != nil {
+		if (videoTrack != nil) && (videoTrack.trackId == pub.SID()) {
 			videoTrack.subscribed = false
 		}
-		writer := subscription.videoWriter
-		if writer != nil {
-			writer.endStream.Break()
-			subscription.videoWriter = nil
-		}
 	}
+	subscription.endWriter(pub.Kind(), pub.SID())
 	subscription.Unlock()
 
+	for _, recorder := range lk.getRecorders() {
+		recorder.trackEnded(rp.Identity(), subscription.isScreenShare, pub.Kind())
+	}
 }
 
 func (lk *ov3Room) lkTrackSubscriptionFailed(sid string, rp *lksdk.RemoteParticipant) {
@@ -338,6 +479,16 @@ func (lk *ov3Room) lkTrackSubscriptionFailed(sid string, rp *lksdk.RemotePartici
 func (lk *ov3Room) lkTrackPublished(pub *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) {
 	root.logger.Debugw(fmt.Sprintf("lkTrackPublished: %s from %s", pub.SID(), rp.Identity()))
 	source := pub.Source()
+	if source == livekit.TrackSource_MICROPHONE {
+		for _, mixer := range lk.getMixers() {
+			mixer.addParticipant(rp.Identity())
+		}
+	}
+	if source == livekit.TrackSource_SCREEN_SHARE {
+		for _, composite := range lk.getComposites() {
+			composite.addScreenShare(rp.Identity())
+		}
+	}
 	switch source {
 	case livekit.TrackSource_CAMERA, livekit.TrackSource_MICROPHONE:
 		subs := lk.getSubscription(rp.Identity(), false)
@@ -363,15 +514,28 @@ func (lk *ov3Room) lkTrackPublished(pub *lksdk.RemoteTrackPublication, rp *lksdk
 }
 
 func (lk *ov3Room) lkTrackUnpublished(publication *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) {
-	//FIXME: if track correspond to the ones willing to subscribe, do a unsubscription
 	root.logger.Debugw(fmt.Sprintf("lkTrackUnpublished:  %s from %s", publication.SID(), rp.Identity()))
+	if publication.Source() == livekit.TrackSource_MICROPHONE {
+		for _, mixer := range lk.getMixers() {
+			mixer.removeParticipant(rp.Identity())
+		}
+	}
+	if publication.Source() == livekit.TrackSource_SCREEN_SHARE {
+		for _, composite := range lk.getComposites() {
+			composite.removeScreenShare(rp.Identity())
+		}
+	}
 	subscription := root.getSubscribedTrack(publication.SID())
 
 	if subscription == nil {
 		return
 	}
 
+	// Subscribers are kept, a track of the same kind published later by the participant is attached to
+	// them from lkTrackPublished
 	root.logger.Debugw(fmt.Sprintf("lkTrackUnpublished: unsubscrbing from  %s", publication.SID()))
+	subscription.releaseTrack(publication)
+	root.removeSubscribedTrack(publication.SID())
 	subscription.room.Lock()
 	subscription.unsubscribe(publication)
 	subscription.room.Unlock()
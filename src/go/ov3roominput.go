@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/livekit/protocol/livekit"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+// Participants of a room an audio mixer or a video composite takes its inputs from
+type roomParticipants struct {
+	room *ov3Room
+	// Participants to take, all participants in the room when nil
+	participants map[string]bool
+}
+
+func newRoomParticipants(room *ov3Room, participants []string) roomParticipants {
+	result := roomParticipants{room: room}
+	if len(participants) > 0 {
+		result.participants = make(map[string]bool)
+		for _, participant := range participants {
+			result.participants[participant] = true
+		}
+	}
+	return result
+}
+
+// Participants published through an ingress of the same room are never taken, they are our own output
+func (rp *roomParticipants) wants(participant string) bool {
+	if rp.room.getIngressByParticipant(participant) != nil {
+		return false
+	}
+	if rp.participants == nil {
+		return true
+	}
+	return rp.participants[participant]
+}
+
+// Participants already in the room publishing a track of the source, or all of them if source is unknown
+func (rp *roomParticipants) publishing(source livekit.TrackSource) []string {
+	roomSvc := rp.room.roomSvc
+	if roomSvc == nil {
+		return nil
+	}
+	result := make([]string, 0)
+	for _, p := range roomSvc.GetRemoteParticipants() {
+		if (source == livekit.TrackSource_UNKNOWN) || (p.GetTrackPublication(source) != nil) {
+			result = append(result, p.Identity())
+		}
+	}
+	return result
+}
+
+// Elements feeding one participant track into the aggregator (audiomixer or compositor) of a room mix.
+// The subscriber bin exposes the depayloaded media once the track is subscribed, decodebin picks the
+// decoder from its caps and the chain converts the decoded media for the aggregator pad.
+type roomInput struct {
+	participant   string
+	screenShare   bool
+	subscriber    *ov3Subscriber
+	bin           *gst.Bin
+	decoder       *gst.Element
+	chain         []*gst.Element
+	aggregatorPad *gst.Pad
+}
+
+// Elements are named after the owner id and the input index, several owners may share the bin. The decoded
+// callback runs on the streaming thread once the decoded media reaches the aggregator.
+func newRoomInput(owner string, index int, bin *gst.Bin, aggregator *gst.Element, participant string, screenShare bool, chain []string, decoded func()) (*roomInput, error) {
+	var err error
+
+	input := &roomInput{
+		participant: participant,
+		screenShare: screenShare,
+		bin:         gst.NewBin(fmt.Sprintf("%s_input_%d", owner, index)),
+		chain:       make([]*gst.Element, 0, len(chain)),
+	}
+	if input.decoder, err = gst.NewElementWithName("decodebin", fmt.Sprintf("%s_decodebin_%d", owner, index)); err != nil {
+		return nil, err
+	}
+	for _, factory := range chain {
+		element, err := gst.NewElementWithName(factory, fmt.Sprintf("%s_%s_%d", owner, factory, index))
+		if err != nil {
+			return nil, err
+		}
+		input.chain = append(input.chain, element)
+	}
+	last := input.chain[len(input.chain)-1]
+
+	if err = bin.AddMany(input.elements()...); err != nil {
+		return nil, err
+	}
+	if err = gst.ElementLinkMany(input.chain...); err != nil {
+		return nil, err
+	}
+	input.aggregatorPad = aggregator.GetRequestPad("sink_%u")
+	if input.aggregatorPad == nil {
+		return nil, fmt.Errorf("cannot get sink pad from %s", aggregator.GetName())
+	}
+	if ret := last.GetStaticPad("src").Link(input.aggregatorPad); ret != gst.PadLinkOK {
+		return nil, fmt.Errorf("cannot link participant %s to %s: %s", participant, aggregator.GetName(), ret.String())
+	}
+
+	input.bin.Connect("pad-added", func(element *gst.Element, pad *gst.Pad) {
+		if ret := pad.Link(input.decoder.GetStaticPad("sink")); ret != gst.PadLinkOK {
+			root.logger.Infow(fmt.Sprintf("roomInput: cannot link participant %s media into %s", participant, owner))
+		}
+	})
+	input.decoder.Connect("pad-added", func(element *gst.Element, pad *gst.Pad) {
+		if ret := pad.Link(input.chain[0].GetStaticPad("sink")); ret != gst.PadLinkOK {
+			root.logger.Infow(fmt.Sprintf("roomInput: cannot link participant %s decoded media into %s", participant, owner))
+			return
+		}
+		if decoded != nil {
+			decoded()
+		}
+	})
+
+	for _, element := range input.elements() {
+		element.SyncStateWithParent()
+	}
+
+	return input, nil
+}
+
+func (input *roomInput) elements() []*gst.Element {
+	return append([]*gst.Element{input.bin.Element, input.decoder}, input.chain...)
+}
+
+// Subscribes the participant track of the kind into the input bin
+func (input *roomInput) subscribe(room *ov3Room, kind lksdk.TrackKind) error {
+	switch kind {
+	case lksdk.TrackKindAudio:
+		input.subscriber = room.addSubscriber(input.participant, input.screenShare, input.bin, nil, defaultSubscriberOptions())
+	case lksdk.TrackKindVideo:
+		input.subscriber = room.addSubscriber(input.participant, input.screenShare, nil, input.bin, defaultSubscriberOptions())
+	default:
+		return errors.New("unknown track kind " + string(kind))
+	}
+	root.addSubscriber(input.subscriber.id, input.subscriber)
+	return nil
+}
+
+func (input *roomInput) destroy(bin *gst.Bin, aggregator *gst.Element) {
+	if input.subscriber != nil {
+		unsubscribeParticipantImpl(input.subscriber.id)
+	}
+
+	if input.aggregatorPad != nil {
+		input.chain[len(input.chain)-1].GetStaticPad("src").Unlink(input.aggregatorPad)
+		aggregator.ReleaseRequestPad(input.aggregatorPad)
+	}
+	elements := input.elements()
+	for _, element := range elements {
+		element.SetState(gst.StateNull)
+	}
+	bin.RemoveMany(elements...)
+}
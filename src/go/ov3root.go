@@ -1,14 +1,9 @@
 package main
 
 import (
-	"fmt"
-	"io"
-	"os"
 	"sync"
-	"time"
 
 	"github.com/go-logr/logr"
-	"github.com/go-logr/logr/funcr"
 	"github.com/livekit/protocol/logger"
 	lksdk "github.com/livekit/server-sdk-go/v2"
 )
@@ -21,65 +16,38 @@ type ov3Root struct {
 	egress           map[string]*ov3Room
 	ingress          map[string]*ov3Ingress
 	publishers       map[string]*ov3Publisher
+	mixers           map[string]*ov3AudioMixer
+	composites       map[string]*ov3VideoComposite
+	recordings       map[string]*ov3Recorder
+	streamIngests    map[string]*ov3StreamIngest
 	logr             logr.Logger
 	logger           logger.Logger
 }
 
 var root ov3Root
-var debug = false
-var logPath string
 
 func init() {
+	configErr := loadConfig()
 	root.initLogger()
-}
-
-func NewFileLogger(filename string) logr.Logger {
-	var writer io.Writer
-
-	file, _ := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-	if file != nil {
-		writer = io.Writer(file)
+	if configErr != nil {
+		root.logger.Warnw("init: configuration partially applied", configErr)
 	}
-
-	return funcr.New(func(prefix, args string) {
-		t := time.Now()
-		timeSuffix := fmt.Sprintf("%d-%02d-%02d %02d:%02d:%02d.%09d",
-			t.Year(), t.Month(), t.Day(),
-			t.Hour(), t.Minute(), t.Second(), t.Nanosecond())
-		if prefix != "" {
-			fmt.Fprintf(writer, "%s - %s: %s\n", timeSuffix, prefix, args)
-		} else {
-			fmt.Fprintf(writer, "%s - %s\n", timeSuffix, args)
-		}
-	}, funcr.Options{
-		Verbosity: 1,
-	})
+	metrics.start()
 }
 
 func (rt *ov3Root) initLogger() {
 	if rt.logger == nil {
-		var ok bool
-		logPath, ok = os.LookupEnv("KURENTO_LOGS_PATH")
-		if !ok {
-			logPath = "/tmp"
-		}
-		t := time.Now()
-		timeSuffix := fmt.Sprintf("%d-%02d-%02dT%02d%02d%02d",
-			t.Year(), t.Month(), t.Day(),
-			t.Hour(), t.Minute(), t.Second())
-		rt.logr = NewFileLogger(logPath + "/goov3_" + timeSuffix + ".log")
+		cfg := getConfig()
+		rt.logr = NewFileLogger(newRotatingWriter(cfg.LogsPath, "goov3"), cfg.LogJSON)
 		logger.InitFromConfig(&logger.Config{
-			Level: "debug",
-			ComponentLevels: map[string]string{
-				"ov3endpoint": "debug",
-			},
+			JSON:            cfg.LogJSON,
+			Level:           cfg.LogLevel,
+			ComponentLevels: cfg.LogLevels,
 		}, "ov3endpoint")
 		logger.SetLogger(logger.LogRLogger(rt.logr), "ov3endpoint")
-		rt.logger = logger.GetLogger()
+		rt.logger = newLeveledLogger(logger.GetLogger(), "ov3endpoint")
 		rt.logger.Infow("logger started")
-		_, ok = os.LookupEnv(("KURENTO_LK_SUBSCRIBER_PTS_LOG"))
-		debug = ok
-		lksdk.SetLogger(rt.logger)
+		lksdk.SetLogger(newLeveledLogger(logger.GetLogger().WithName("livekit"), "livekit"))
 	}
 }
 
@@ -154,6 +122,150 @@ func (rt *ov3Root) getPublisher(id string) *ov3Publisher {
 	return result
 }
 
+func (rt *ov3Root) addMixer(id string, mixer *ov3AudioMixer) {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if rt.mixers == nil {
+		rt.mixers = make(map[string]*ov3AudioMixer)
+	}
+	rt.mixers[id] = mixer
+}
+
+func (rt *ov3Root) deleteMixer(id string) *ov3AudioMixer {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if rt.mixers == nil {
+		rt.mixers = make(map[string]*ov3AudioMixer)
+	}
+	mixer := rt.mixers[id]
+	if mixer != nil {
+		delete(rt.mixers, id)
+	}
+
+	return mixer
+}
+
+func (rt *ov3Root) getMixer(id string) *ov3AudioMixer {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if rt.mixers == nil {
+		rt.mixers = make(map[string]*ov3AudioMixer)
+	}
+	result := rt.mixers[id]
+	return result
+}
+
+func (rt *ov3Root) addComposite(id string, composite *ov3VideoComposite) {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if rt.composites == nil {
+		rt.composites = make(map[string]*ov3VideoComposite)
+	}
+	rt.composites[id] = composite
+}
+
+func (rt *ov3Root) deleteComposite(id string) *ov3VideoComposite {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if rt.composites == nil {
+		rt.composites = make(map[string]*ov3VideoComposite)
+	}
+	composite := rt.composites[id]
+	if composite != nil {
+		delete(rt.composites, id)
+	}
+
+	return composite
+}
+
+func (rt *ov3Root) getComposite(id string) *ov3VideoComposite {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if rt.composites == nil {
+		rt.composites = make(map[string]*ov3VideoComposite)
+	}
+	result := rt.composites[id]
+	return result
+}
+
+func (rt *ov3Root) addRecording(id string, recorder *ov3Recorder) {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if rt.recordings == nil {
+		rt.recordings = make(map[string]*ov3Recorder)
+	}
+	rt.recordings[id] = recorder
+}
+
+func (rt *ov3Root) deleteRecording(id string) *ov3Recorder {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if rt.recordings == nil {
+		rt.recordings = make(map[string]*ov3Recorder)
+	}
+	recorder := rt.recordings[id]
+	if recorder != nil {
+		delete(rt.recordings, id)
+	}
+
+	return recorder
+}
+
+func (rt *ov3Root) getRecording(id string) *ov3Recorder {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if rt.recordings == nil {
+		rt.recordings = make(map[string]*ov3Recorder)
+	}
+	result := rt.recordings[id]
+	return result
+}
+
+func (rt *ov3Root) addStreamIngest(id string, ingest *ov3StreamIngest) {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if rt.streamIngests == nil {
+		rt.streamIngests = make(map[string]*ov3StreamIngest)
+	}
+	rt.streamIngests[id] = ingest
+}
+
+func (rt *ov3Root) deleteStreamIngest(id string) *ov3StreamIngest {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if rt.streamIngests == nil {
+		rt.streamIngests = make(map[string]*ov3StreamIngest)
+	}
+	ingest := rt.streamIngests[id]
+	if ingest != nil {
+		delete(rt.streamIngests, id)
+	}
+
+	return ingest
+}
+
+func (rt *ov3Root) getStreamIngest(id string) *ov3StreamIngest {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if rt.streamIngests == nil {
+		rt.streamIngests = make(map[string]*ov3StreamIngest)
+	}
+	result := rt.streamIngests[id]
+	return result
+}
+
 func (rt *ov3Root) addSubscribedTrack(trackId string, subscription *ov3Subscription) {
 	rt.Lock()
 	defer rt.Unlock()
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+type writerState struct {
+	TrackId     string     `json:"trackId"`
+	Kind        string     `json:"kind"`
+	Codec       string     `json:"codec"`
+	PayloadType uint8      `json:"payloadType"`
+	ClockRate   uint32     `json:"clockRate"`
+	SSRC        uint32     `json:"ssrc"`
+	State       string     `json:"state"`
+	Muted       bool       `json:"muted"`
+	Stalled     bool       `json:"stalled"`
+	Dropping    bool       `json:"dropping"`
+	Ended       bool       `json:"ended"`
+	Nack        *nackStats `json:"nack,omitempty"`
+}
+
+type trackState struct {
+	TrackId    string `json:"trackId"`
+	Kind       string `json:"kind"`
+	Source     string `json:"source"`
+	Subscribed bool   `json:"subscribed"`
+}
+
+type subscriberState struct {
+	Id         string     `json:"id"`
+	AudioReady bool       `json:"audioReady"`
+	VideoReady bool       `json:"videoReady"`
+	HasAudio   bool       `json:"hasAudioBin"`
+	HasVideo   bool       `json:"hasVideoBin"`
+	Audio      mediaState `json:"audio"`
+	Video      mediaState `json:"video"`
+
+	Options subscriberOptions `json:"options"`
+}
+
+type subscriptionState struct {
+	Participant string            `json:"participant"`
+	ScreenShare bool              `json:"screenShare"`
+	EgressId    string            `json:"egressId"`
+	AudioTrack  *trackState       `json:"audioTrack,omitempty"`
+	VideoTrack  *trackState       `json:"videoTrack,omitempty"`
+	AudioWriter *writerState      `json:"audioWriter,omitempty"`
+	VideoWriter *writerState      `json:"videoWriter,omitempty"`
+	Subscribers []subscriberState `json:"subscribers"`
+}
+
+type mixerInputState struct {
+	Participant  string  `json:"participant"`
+	SubscriberId string  `json:"subscriberId"`
+	Gain         float64 `json:"gain"`
+}
+
+type mixerState struct {
+	Id           string            `json:"id"`
+	Participants []string          `json:"participants,omitempty"`
+	Inputs       []mixerInputState `json:"inputs"`
+}
+
+type compositeInputState struct {
+	Participant  string `json:"participant"`
+	ScreenShare  bool   `json:"screenShare"`
+	SubscriberId string `json:"subscriberId"`
+	Active       bool   `json:"active"`
+}
+
+type compositeState struct {
+	Id      string                `json:"id"`
+	Layout  string                `json:"layout"`
+	Speaker string                `json:"speaker"`
+	Inputs  []compositeInputState `json:"inputs"`
+}
+
+type recordingState struct {
+	Id          string   `json:"id"`
+	EgressId    string   `json:"egressId"`
+	Participant string   `json:"participant"`
+	ScreenShare bool     `json:"screenShare"`
+	Format      string   `json:"format"`
+	State       string   `json:"state"`
+	Files       []string `json:"files"`
+	Error       string   `json:"error,omitempty"`
+	StartTime   string   `json:"startTime,omitempty"`
+	EndTime     string   `json:"endTime,omitempty"`
+}
+
+type roomState struct {
+	Room            string              `json:"room"`
+	EgressId        string              `json:"egressId"`
+	Connected       bool                `json:"connected"`
+	Aligned         bool                `json:"aligned"`
+	LkState         string              `json:"lkState,omitempty"`
+	Subscriptions   []subscriptionState `json:"subscriptions"`
+	SSSubscriptions []subscriptionState `json:"ssSubscriptions"`
+	Ingress         []string            `json:"ingress"`
+	Mixers          []mixerState        `json:"mixers"`
+	Composites      []compositeState    `json:"composites"`
+}
+
+type serviceState struct {
+	Url   string      `json:"url"`
+	Rooms []roomState `json:"rooms"`
+}
+
+type trackPublisherState struct {
+	Kind    string `json:"kind"`
+	Sid     string `json:"sid"`
+	Codec   string `json:"codec"`
+	Live    bool   `json:"live"`
+	Muted   bool   `json:"muted"`
+	Stalled bool   `json:"stalled"`
+	// Last bitrate asked to the encoder, 0 if there was no congestion feedback yet
+	TargetBitrate uint64 `json:"targetBitrate,omitempty"`
+}
+
+type publisherState struct {
+	Id        string               `json:"id"`
+	IngressId string               `json:"ingressId"`
+	Audio     *trackPublisherState `json:"audio,omitempty"`
+	Video     *trackPublisherState `json:"video,omitempty"`
+}
+
+type ingressState struct {
+	IngressId       string `json:"ingressId"`
+	ParticipantName string `json:"participantName"`
+	Room            string `json:"room"`
+	Connected       bool   `json:"connected"`
+	MainPub         string `json:"mainPublisher,omitempty"`
+	ScreenSharePub  string `json:"screenSharePublisher,omitempty"`
+}
+
+type streamIngestState struct {
+	Id          string `json:"id"`
+	IngressId   string `json:"ingressId"`
+	PublisherId string `json:"publisherId,omitempty"`
+	Url         string `json:"url"`
+	ScreenShare bool   `json:"screenShare"`
+	File        bool   `json:"file"`
+	Loop        bool   `json:"loop,omitempty"`
+	Paused      bool   `json:"paused,omitempty"`
+	Ended       bool   `json:"ended,omitempty"`
+	Connected   bool   `json:"connected"`
+	Reconnects  uint   `json:"reconnects"`
+	LastError   string `json:"lastError,omitempty"`
+}
+
+type rootState struct {
+	Timestamp        string              `json:"timestamp"`
+	Services         []serviceState      `json:"services"`
+	Egress           map[string]string   `json:"egress"`
+	Subscribers      []string            `json:"subscribers"`
+	SubscribedTracks map[string]string   `json:"subscribedTracks"`
+	Ingress          []ingressState      `json:"ingress"`
+	Publishers       []publisherState    `json:"publishers"`
+	Recordings       []recordingState    `json:"recordings"`
+	StreamIngests    []streamIngestState `json:"streamIngests"`
+	Config           *ov3Config          `json:"config"`
+}
+
+func (s state) String() string {
+	switch s {
+	case statePlaying:
+		return "playing"
+	case stateMuted:
+		return "muted"
+	case stateUnmuting:
+		return "unmuting"
+	default:
+		return "unknown"
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (w *AppWriter) snapshot() *writerState {
+	if w == nil {
+		return nil
+	}
+	result := &writerState{
+		Kind:        string(w.kind),
+		Codec:       string(w.codec),
+		PayloadType: uint8(w.PayloadType),
+		ClockRate:   w.ClockRate,
+		State:       w.state.String(),
+		Muted:       w.muted.Load(),
+		Stalled:     w.stalled.Load(),
+		Ended:       w.endStream.IsBroken(),
+	}
+	if w.pub != nil {
+		result.TrackId = w.pub.SID()
+	}
+	if w.track != nil {
+		result.SSRC = uint32(w.track.SSRC())
+	}
+	w.gapLock.RLock()
+	result.Dropping = w.dropping
+	w.gapLock.RUnlock()
+	if w.nack != nil {
+		nack := w.nack.snapshot()
+		result.Nack = &nack
+	}
+
+	return result
+}
+
+func (track *lkTrack) snapshot() *trackState {
+	if track == nil {
+		return nil
+	}
+	return &trackState{
+		TrackId:    track.trackId,
+		Kind:       string(track.trackType),
+		Source:     track.trackSource.String(),
+		Subscribed: track.subscribed,
+	}
+}
+
+// Lock order: subscription -> subscriber, same as createWriter and PushRTCPPacket
+func (subs *ov3Subscription) snapshot() subscriptionState {
+	subs.RLock()
+	defer subs.RUnlock()
+
+	result := subscriptionState{
+		Participant: subs.participant,
+		ScreenShare: subs.isScreenShare,
+		EgressId:    subs.egressId,
+		AudioTrack:  subs.audioTrack.snapshot(),
+		VideoTrack:  subs.videoTrack.snapshot(),
+		AudioWriter: subs.audioWriter.snapshot(),
+		VideoWriter: subs.videoWriter.snapshot(),
+		Subscribers: make([]subscriberState, 0, len(subs.subscribers)),
+	}
+	for _, subscriber := range subs.subscribers {
+		if subscriber == nil {
+			continue
+		}
+		subscriber.RLock()
+		result.Subscribers = append(result.Subscribers, subscriberState{
+			Id:         subscriber.id,
+			AudioReady: subscriber.audioReady,
+			VideoReady: subscriber.videoReady,
+			HasAudio:   subscriber.audioRtpSource != nil,
+			HasVideo:   subscriber.videoRtpSource != nil,
+			Audio:      subscriber.audioState,
+			Video:      subscriber.videoState,
+			Options:    subscriber.options,
+		})
+		subscriber.RUnlock()
+	}
+
+	return result
+}
+
+func (mixer *ov3AudioMixer) snapshot() mixerState {
+	mixer.RLock()
+	defer mixer.RUnlock()
+
+	result := mixerState{
+		Id:           mixer.id,
+		Participants: sortedKeys(mixer.participants),
+		Inputs:       make([]mixerInputState, 0, len(mixer.inputs)),
+	}
+	for _, participant := range sortedKeys(mixer.inputs) {
+		gain, ok := mixer.gains[participant]
+		if !ok {
+			gain = 1.0
+		}
+		result.Inputs = append(result.Inputs, mixerInputState{
+			Participant:  participant,
+			SubscriberId: mixer.inputs[participant].subscriber.id,
+			Gain:         gain,
+		})
+	}
+
+	return result
+}
+
+func (composite *ov3VideoComposite) snapshot() compositeState {
+	composite.RLock()
+	defer composite.RUnlock()
+
+	result := compositeState{
+		Id:      composite.id,
+		Layout:  composite.options.Layout,
+		Speaker: composite.speaker,
+		Inputs:  make([]compositeInputState, 0, len(composite.inputs)),
+	}
+	for _, key := range sortedKeys(composite.inputs) {
+		input := composite.inputs[key]
+		result.Inputs = append(result.Inputs, compositeInputState{
+			Participant:  input.participant,
+			ScreenShare:  input.screenShare,
+			SubscriberId: input.subscriber.id,
+			Active:       input.active,
+		})
+	}
+
+	return result
+}
+
+func (recorder *ov3Recorder) snapshot() recordingState {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	result := recordingState{
+		Id:          recorder.id,
+		EgressId:    recorder.room.egressId,
+		Participant: recorder.participant,
+		ScreenShare: recorder.screenShare,
+		Format:      recorder.options.Format,
+		State:       recorder.state,
+		Files:       append([]string{}, recorder.files...),
+		Error:       recorder.err,
+	}
+	if !recorder.startTime.IsZero() {
+		result.StartTime = recorder.startTime.UTC().Format(time.RFC3339Nano)
+	}
+	if !recorder.endTime.IsZero() {
+		result.EndTime = recorder.endTime.UTC().Format(time.RFC3339Nano)
+	}
+	return result
+}
+
+func (ingest *ov3StreamIngest) snapshot() streamIngestState {
+	ingest.Lock()
+	defer ingest.Unlock()
+
+	result := streamIngestState{
+		Id:          ingest.id,
+		IngressId:   ingest.ingressId,
+		Url:         ingest.redactedUrl(),
+		ScreenShare: ingest.screenShare,
+		File:        ingest.file,
+		Loop:        ingest.loop,
+		Paused:      ingest.paused,
+		Ended:       ingest.ended,
+		Connected:   ingest.connected,
+		Reconnects:  ingest.reconnects,
+		LastError:   ingest.lastError,
+	}
+	if ingest.publisher != nil {
+		result.PublisherId = ingest.publisher.id
+	}
+	return result
+}
+
+// Lock order: room -> subscription -> subscriber. Mixers and composites lock the room when adding
+// participants, so they are copied before taking the room lock
+func (room *ov3Room) snapshot() roomState {
+	mixers := room.getMixers()
+	mixerStates := make([]mixerState, 0, len(mixers))
+	for _, mixer := range mixers {
+		mixerStates = append(mixerStates, mixer.snapshot())
+	}
+	sort.Slice(mixerStates, func(i, j int) bool { return mixerStates[i].Id < mixerStates[j].Id })
+	composites := room.getComposites()
+	compositeStates := make([]compositeState, 0, len(composites))
+	for _, composite := range composites {
+		compositeStates = append(compositeStates, composite.snapshot())
+	}
+	sort.Slice(compositeStates, func(i, j int) bool { return compositeStates[i].Id < compositeStates[j].Id })
+
+	room.RLock()
+	defer room.RUnlock()
+
+	result := roomState{
+		Room:            room.room,
+		EgressId:        room.egressId,
+		Connected:       room.connected,
+		Aligned:         room.aligned,
+		Subscriptions:   make([]subscriptionState, 0, len(room.subscriptions)),
+		SSSubscriptions: make([]subscriptionState, 0, len(room.ssSubscriptions)),
+		Ingress:         sortedKeys(room.ingress),
+		Mixers:          mixerStates,
+		Composites:      compositeStates,
+	}
+	if room.roomSvc != nil {
+		result.LkState = string(room.roomSvc.ConnectionState())
+	}
+	for _, participant := range sortedKeys(room.subscriptions) {
+		result.Subscriptions = append(result.Subscriptions, room.subscriptions[participant].snapshot())
+	}
+	for _, participant := range sortedKeys(room.ssSubscriptions) {
+		result.SSSubscriptions = append(result.SSSubscriptions, room.ssSubscriptions[participant].snapshot())
+	}
+
+	return result
+}
+
+func (svcs *ov3Service) snapshot() serviceState {
+	svcs.RLock()
+	rooms := make([]*ov3Room, 0, len(svcs.rooms))
+	for _, name := range sortedKeys(svcs.rooms) {
+		rooms = append(rooms, svcs.rooms[name])
+	}
+	result := serviceState{
+		Url:   svcs.url,
+		Rooms: make([]roomState, 0, len(rooms)),
+	}
+	svcs.RUnlock()
+
+	// Rooms are locked after releasing the service, as some room paths take the service lock
+	for _, room := range rooms {
+		result.Rooms = append(result.Rooms, room.snapshot())
+	}
+
+	return result
+}
+
+func (tr *ov3TrackPublisher) snapshot() *trackPublisherState {
+	if tr == nil {
+		return nil
+	}
+	tr.RLock()
+	defer tr.RUnlock()
+
+	return &trackPublisherState{
+		Kind:          string(tr.kind),
+		Sid:           tr.sid,
+		Codec:         tr.codec,
+		Live:          (tr.track != nil) && !tr.endStream.IsBroken(),
+		Muted:         tr.muted.Load(),
+		Stalled:       tr.stalled.Load(),
+		TargetBitrate: tr.targetBitrate.Load(),
+	}
+}
+
+func (pub *ov3Publisher) snapshot() publisherState {
+	pub.RLock()
+	defer pub.RUnlock()
+
+	result := publisherState{
+		Id:    pub.id,
+		Audio: pub.audioPublisher.snapshot(),
+		Video: pub.videoPublisher.snapshot(),
+	}
+	if pub.ingress != nil {
+		result.IngressId = pub.ingress.ingressId
+	}
+
+	return result
+}
+
+func (ing *ov3Ingress) snapshot() ingressState {
+	ing.RLock()
+	defer ing.RUnlock()
+
+	result := ingressState{
+		IngressId:       ing.ingressId,
+		ParticipantName: ing.participantName,
+		Connected:       ing.connected,
+	}
+	if ing.room != nil {
+		result.Room = ing.room.room
+	}
+	if ing.mainPub != nil {
+		result.MainPub = ing.mainPub.id
+	}
+	if ing.screenSharePub != nil {
+		result.ScreenSharePub = ing.screenSharePub.id
+	}
+
+	return result
+}
+
+// Builds a snapshot of everything tracked by root. The root lock is only held while copying its maps,
+// then every object is locked on its own following service -> room -> subscription -> subscriber, so that
+// no lock is ever taken in reverse order with respect to the regular subscribe/unsubscribe paths.
+func (rt *ov3Root) snapshot() rootState {
+	rt.RLock()
+	services := make([]*ov3Service, 0, len(rt.services))
+	for _, url := range sortedKeys(rt.services) {
+		services = append(services, rt.services[url])
+	}
+	ingress := make([]*ov3Ingress, 0, len(rt.ingress))
+	for _, id := range sortedKeys(rt.ingress) {
+		ingress = append(ingress, rt.ingress[id])
+	}
+	publishers := make([]*ov3Publisher, 0, len(rt.publishers))
+	for _, id := range sortedKeys(rt.publishers) {
+		publishers = append(publishers, rt.publishers[id])
+	}
+	recordings := make([]*ov3Recorder, 0, len(rt.recordings))
+	for _, id := range sortedKeys(rt.recordings) {
+		recordings = append(recordings, rt.recordings[id])
+	}
+	streamIngests := make([]*ov3StreamIngest, 0, len(rt.streamIngests))
+	for _, id := range sortedKeys(rt.streamIngests) {
+		streamIngests = append(streamIngests, rt.streamIngests[id])
+	}
+	result := rootState{
+		Timestamp:        time.Now().UTC().Format(time.RFC3339Nano),
+		Services:         make([]serviceState, 0, len(services)),
+		Egress:           make(map[string]string, len(rt.egress)),
+		Subscribers:      sortedKeys(rt.subscribers),
+		SubscribedTracks: make(map[string]string, len(rt.subscribedTracks)),
+		Ingress:          make([]ingressState, 0, len(ingress)),
+		Publishers:       make([]publisherState, 0, len(publishers)),
+		Recordings:       make([]recordingState, 0, len(recordings)),
+		StreamIngests:    make([]streamIngestState, 0, len(streamIngests)),
+		Config:           getConfig(),
+	}
+	for egressId, room := range rt.egress {
+		if room != nil {
+			result.Egress[egressId] = room.room
+		}
+	}
+	for trackId, subscription := range rt.subscribedTracks {
+		if subscription != nil {
+			result.SubscribedTracks[trackId] = subscription.participant
+		}
+	}
+	rt.RUnlock()
+
+	for _, svc := range services {
+		result.Services = append(result.Services, svc.snapshot())
+	}
+	for _, ing := range ingress {
+		result.Ingress = append(result.Ingress, ing.snapshot())
+	}
+	for _, pub := range publishers {
+		result.Publishers = append(result.Publishers, pub.snapshot())
+	}
+	for _, recorder := range recordings {
+		result.Recordings = append(result.Recordings, recorder.snapshot())
+	}
+	for _, ingest := range streamIngests {
+		result.StreamIngests = append(result.StreamIngests, ingest.snapshot())
+	}
+
+	return result
+}
+
+func dumpStateImpl() string {
+	state, err := json.Marshal(root.snapshot())
+	if err != nil {
+		root.logger.Errorw("dumpStateImpl: could not marshal state", err)
+		return "ERROR: " + err.Error()
+	}
+	return string(state)
+}
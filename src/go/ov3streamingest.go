@@ -0,0 +1,765 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	guuid "github.com/google/uuid"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+// Schemes pulled by uridecodebin, through rtmpsrc, srtsrc and rtspsrc
+var ingestSchemes = map[string]bool{
+	"rtmp":  true,
+	"rtmps": true,
+	"srt":   true,
+	"rtsp":  true,
+	"rtsps": true,
+}
+
+var ingestVideoCodecs = map[string]bool{
+	"vp8":  true,
+	"h264": true,
+}
+
+// Query parameters carrying credentials, SRT passphrases and stream ids among them
+var ingestSensitiveParams = map[string]bool{
+	"passphrase": true,
+	"streamid":   true,
+	"key":        true,
+	"token":      true,
+	"password":   true,
+	"secret":     true,
+	"auth":       true,
+}
+
+// Placeholder of redacted values, the one used by url.Redacted
+const redactedValue = "xxxxx"
+
+const (
+	ingestReconnectDelay    = time.Second
+	ingestMaxReconnectDelay = 30 * time.Second
+	ingestBusPoll           = 500 * time.Millisecond
+)
+
+// Options given by Kurento when starting a stream ingest, bitrates in kbps and latency in milliseconds
+type ingestOptions struct {
+	Audio        bool   `json:"audio"`
+	Video        bool   `json:"video"`
+	VideoCodec   string `json:"videoCodec"`
+	VideoBitrate uint   `json:"videoBitrate"`
+	AudioBitrate uint   `json:"audioBitrate"`
+	Latency      uint   `json:"latency"`
+}
+
+func parseIngestOptions(options string) (ingestOptions, error) {
+	result := ingestOptions{
+		Audio:        true,
+		Video:        true,
+		VideoCodec:   "vp8",
+		VideoBitrate: 1500,
+		AudioBitrate: 64,
+		Latency:      200,
+	}
+
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &result); err != nil {
+			return result, err
+		}
+	}
+	if !ingestVideoCodecs[result.VideoCodec] {
+		return result, fmt.Errorf("unsupported ingest video codec %s", result.VideoCodec)
+	}
+	if !result.Audio && !result.Video {
+		return result, errors.New("ingest needs audio, video or both")
+	}
+	if (result.VideoBitrate == 0) || (result.AudioBitrate == 0) {
+		return result, errors.New("ingest bitrates must be greater than 0")
+	}
+
+	return result, nil
+}
+
+// Errors do not quote the source, it may carry credentials
+func validateIngestUrl(source string) error {
+	parsed, err := url.Parse(source)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("cannot parse ingest url: %w", urlErr.Err)
+		}
+		return errors.New("cannot parse ingest url")
+	}
+	if !ingestSchemes[strings.ToLower(parsed.Scheme)] {
+		return fmt.Errorf("unsupported ingest scheme %s", parsed.Scheme)
+	}
+	return nil
+}
+
+// Pulls an RTMP, SRT or RTSP stream with a pipeline of its own and publishes it on an ingress as any
+// other publisher. Streams already in the codec published, Opus and VP8 or H264, are only demuxed and
+// passed through, any other is decoded and encoded again, so whatever the camera sends ends in a codec
+// the room accepts. When the source fails or ends, only the source is rebuilt, the encoders and the
+// published tracks stay in place.
+// Local files are published the same way, paced to real time by clocksync. They are not reconnected,
+// reaching the end raises an event and, when looping, playback restarts with segment seeks so the
+// running time keeps increasing.
+type ov3StreamIngest struct {
+	sync.Mutex
+	id          string
+	ingressId   string
+	url         string
+	screenShare bool
+	options     ingestOptions
+	pipeline    *gst.Pipeline
+	source      *gst.Element
+	decoders    []*gst.Element
+	audio       *ingestBranch
+	video       *ingestBranch
+	publisher   *ov3Publisher
+	file        bool
+	loop        bool
+
+	connected    bool
+	paused       bool
+	ended        bool
+	looping      bool
+	reconnecting bool
+	stopped      bool
+	reconnects   uint
+	backoff      time.Duration
+	lastError    string
+	done         chan struct{}
+}
+
+func newStreamIngest(ingressId string, source string, screenShare bool, options ingestOptions) (*ov3StreamIngest, error) {
+	var err error
+
+	ingest := &ov3StreamIngest{
+		id:          "GSTSI_" + guuid.New().String(),
+		ingressId:   ingressId,
+		url:         source,
+		screenShare: screenShare,
+		options:     options,
+		backoff:     ingestReconnectDelay,
+		done:        make(chan struct{}),
+	}
+
+	if ingest.pipeline, err = gst.NewPipeline(ingest.id); err != nil {
+		return nil, err
+	}
+
+	return ingest, nil
+}
+
+func newFileIngest(ingressId string, path string, loop bool, screenShare bool) (*ov3StreamIngest, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(absolute); err != nil {
+		return nil, err
+	}
+	options, _ := parseIngestOptions("")
+	source := url.URL{Scheme: "file", Path: absolute}
+	ingest, err := newStreamIngest(ingressId, source.String(), screenShare, options)
+	if err != nil {
+		return nil, err
+	}
+	ingest.file = true
+	ingest.loop = loop
+
+	return ingest, nil
+}
+
+// Elements of one kind between the source and the publisher bin. Decoded media is encoded again,
+// compressed media in the codec published goes straight to the funnel feeding the publisher
+type ingestBranch struct {
+	decoded    *gst.Element
+	compressed *gst.Element
+	output     *gst.Element
+	bin        *gst.Bin
+}
+
+func (ingest *ov3StreamIngest) createElements(kind lksdk.TrackKind, chain string, factories []string) ([]*gst.Element, error) {
+	elements := make([]*gst.Element, 0, len(factories))
+	for i, factory := range factories {
+		element, err := gst.NewElementWithName(factory, fmt.Sprintf("ingest_%s_%s_%d", kind, chain, i))
+		if err != nil {
+			return nil, err
+		}
+		switch factory {
+		case "opusenc":
+			SetArg(element.Object, "bitrate", fmt.Sprintf("%d", ingest.options.AudioBitrate*1000))
+		case "vp8enc":
+			SetArg(element.Object, "deadline", "1")
+			SetArg(element.Object, "end-usage", "cbr")
+			SetArg(element.Object, "lag-in-frames", "0")
+			SetArg(element.Object, "error-resilient", "partitions")
+			SetArg(element.Object, "target-bitrate", fmt.Sprintf("%d", ingest.options.VideoBitrate*1000))
+		case "x264enc":
+			SetArg(element.Object, "tune", "zerolatency")
+			SetArg(element.Object, "speed-preset", "ultrafast")
+			SetArg(element.Object, "bitrate", fmt.Sprintf("%d", ingest.options.VideoBitrate))
+		case "capsfilter":
+			// Browsers are only required to decode constrained baseline
+			element.SetProperty("caps", gst.NewCapsFromString("video/x-h264,profile=constrained-baseline"))
+		}
+		elements = append(elements, element)
+	}
+
+	if err := ingest.pipeline.AddMany(elements...); err != nil {
+		return nil, err
+	}
+	if len(elements) > 1 {
+		if err := gst.ElementLinkMany(elements...); err != nil {
+			return nil, err
+		}
+	}
+	return elements, nil
+}
+
+// Builds the branch of one kind, both chains start with a queue and join in a funnel, only one of them
+// is linked to the source at a time
+func (ingest *ov3StreamIngest) createBranch(kind lksdk.TrackKind) (*ingestBranch, error) {
+	var encoding []string
+
+	if kind == lksdk.TrackKindAudio {
+		encoding = []string{"queue", "audioconvert", "audioresample", "opusenc"}
+	} else if ingest.options.VideoCodec == "h264" {
+		encoding = []string{"queue", "videoconvert", "x264enc", "capsfilter"}
+	} else {
+		encoding = []string{"queue", "videoconvert", "vp8enc"}
+	}
+	passthrough := []string{"queue"}
+	if ingest.file {
+		encoding = append([]string{encoding[0], "clocksync"}, encoding[1:]...)
+		passthrough = append(passthrough, "clocksync")
+	}
+
+	encoder, err := ingest.createElements(kind, "decoded", encoding)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := ingest.createElements(kind, "compressed", passthrough)
+	if err != nil {
+		return nil, err
+	}
+	funnel, err := gst.NewElementWithName("funnel", fmt.Sprintf("ingest_%s_funnel", kind))
+	if err != nil {
+		return nil, err
+	}
+	if err = ingest.pipeline.Add(funnel); err != nil {
+		return nil, err
+	}
+	if err = encoder[len(encoder)-1].Link(funnel); err != nil {
+		return nil, err
+	}
+	if err = compressed[len(compressed)-1].Link(funnel); err != nil {
+		return nil, err
+	}
+
+	bin := gst.NewBin(fmt.Sprintf("ingest_%s_publisher", kind))
+	if err := ingest.pipeline.Add(bin.Element); err != nil {
+		return nil, err
+	}
+
+	branch := &ingestBranch{decoded: encoder[0], compressed: compressed[0], output: funnel, bin: bin}
+	ingest.dropEndOfStream(branch.decoded)
+	ingest.dropEndOfStream(branch.compressed)
+
+	return branch, nil
+}
+
+// Sources ending are handled by reconnecting or seeking, so their EOS never reaches the publisher
+func (ingest *ov3StreamIngest) dropEndOfStream(input *gst.Element) {
+	input.GetStaticPad("sink").AddProbe(gst.PadProbeTypeEventDownstream, func(pad *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		event := info.GetEvent()
+		if (event != nil) && (event.Type() == gst.EventTypeEOS) {
+			if ingest.file {
+				go ingest.endOfFile()
+			} else {
+				go ingest.scheduleReconnect("end of stream")
+			}
+			return gst.PadProbeDrop
+		}
+		return gst.PadProbeOK
+	})
+}
+
+// Codecs published as they come, the Opus and video codec the ingest would encode to anyway, so the
+// published tracks keep their codec across reconnections
+func (ingest *ov3StreamIngest) passthroughCaps() string {
+	return "audio/x-opus;video/x-" + ingest.options.VideoCodec
+}
+
+// H264 with B-frames cannot be sent to browsers, only baseline profiles pass through. Without a profile
+// in the caps the stream is taken as is
+func (ingest *ov3StreamIngest) canPassThrough(caps *gst.Caps) bool {
+	if !caps.CanIntersect(gst.NewCapsFromString(ingest.passthroughCaps())) {
+		return false
+	}
+	structure := caps.GetStructureAt(0)
+	switch structure.Name() {
+	case "audio/x-opus":
+		channels, err := getIntFieldFromGstStructure(structure, "channels")
+		return (err != nil) || (channels <= 2)
+	case "video/x-h264":
+		profile, err := structure.GetValue("profile")
+		if err != nil {
+			return true
+		}
+		return (profile == "baseline") || (profile == "constrained-baseline")
+	}
+	return true
+}
+
+// The publisher bins get their sink elements from the ingress, then they are fed by the encoders
+func (ingest *ov3StreamIngest) start() error {
+	var audioBin *gst.Bin
+	var videoBin *gst.Bin
+	var err error
+
+	if ingest.options.Audio {
+		if ingest.audio, err = ingest.createBranch(lksdk.TrackKindAudio); err != nil {
+			return err
+		}
+		audioBin = ingest.audio.bin
+	}
+	if ingest.options.Video {
+		if ingest.video, err = ingest.createBranch(lksdk.TrackKindVideo); err != nil {
+			return err
+		}
+		videoBin = ingest.video.bin
+	}
+
+	result := publishBinsImpl(ingest.screenShare, ingest.ingressId, audioBin, videoBin)
+	if strings.HasPrefix(result, "ERROR") {
+		return errors.New(result)
+	}
+	ingest.publisher = root.getPublisher(result)
+
+	if err = linkPublisherBin(ingest.audio); err != nil {
+		return err
+	}
+	if err = linkPublisherBin(ingest.video); err != nil {
+		return err
+	}
+	if err = ingest.createSource(); err != nil {
+		return err
+	}
+	go ingest.watchBus()
+
+	// Sources not reachable yet are retried as any later failure
+	if err = ingest.pipeline.SetState(gst.StatePlaying); err != nil {
+		ingest.scheduleReconnect(err.Error())
+	}
+
+	return nil
+}
+
+func linkPublisherBin(branch *ingestBranch) error {
+	if branch == nil {
+		return nil
+	}
+	sinkPad := branch.bin.GetStaticPad("sink")
+	if sinkPad == nil {
+		return errors.New("publisher bin has no sink pad")
+	}
+	if ret := branch.output.GetStaticPad("src").Link(sinkPad); ret != gst.PadLinkOK {
+		return fmt.Errorf("cannot link ingest branch to publisher: %s", ret.String())
+	}
+	return nil
+}
+
+func (ingest *ov3StreamIngest) createSource() error {
+	source, err := gst.NewElement("uridecodebin")
+	if err != nil {
+		return err
+	}
+	source.SetProperty("uri", ingest.url)
+	// Decoding stops at the codecs passed through
+	source.SetProperty("caps", gst.NewCapsFromString("audio/x-raw(ANY);video/x-raw(ANY);"+ingest.passthroughCaps()))
+	source.Connect("source-setup", func(element *gst.Element, src *gst.Element) {
+		// rtspsrc and srtsrc both have a latency in milliseconds
+		if _, err := src.GetPropertyType("latency"); err == nil {
+			SetArg(src.Object, "latency", fmt.Sprintf("%d", ingest.options.Latency))
+		}
+	})
+	source.Connect("pad-added", func(element *gst.Element, pad *gst.Pad) {
+		ingest.linkSource(pad)
+	})
+
+	if err = ingest.pipeline.Add(source); err != nil {
+		return err
+	}
+	ingest.Lock()
+	ingest.source = source
+	ingest.Unlock()
+	source.SyncStateWithParent()
+
+	return nil
+}
+
+func (ingest *ov3StreamIngest) linkSource(pad *gst.Pad) {
+	caps := pad.GetCurrentCaps()
+	if caps == nil {
+		return
+	}
+	var branch *ingestBranch
+	mediaType := caps.GetStructureAt(0).Name()
+	if strings.HasPrefix(mediaType, "audio/") {
+		branch = ingest.audio
+	} else if strings.HasPrefix(mediaType, "video/") {
+		branch = ingest.video
+	}
+	if branch == nil {
+		root.logger.Debugw(fmt.Sprintf("linkSource: ignoring %s stream on ingest %s", mediaType, ingest.id))
+		return
+	}
+	if branch.decoded.GetStaticPad("sink").IsLinked() || branch.compressed.GetStaticPad("sink").IsLinked() {
+		root.logger.Debugw(fmt.Sprintf("linkSource: ignoring additional %s stream on ingest %s", mediaType, ingest.id))
+		return
+	}
+
+	input := branch.decoded
+	if !strings.HasSuffix(mediaType, "/x-raw") {
+		if !ingest.canPassThrough(caps) {
+			ingest.decodeSource(pad, mediaType)
+			return
+		}
+		input = branch.compressed
+	}
+	if ret := pad.Link(input.GetStaticPad("sink")); ret != gst.PadLinkOK {
+		root.logger.Infow(fmt.Sprintf("linkSource: cannot link %s stream on ingest %s: %s", mediaType, ingest.id, ret.String()))
+		return
+	}
+
+	ingest.Lock()
+	ingest.connected = true
+	ingest.backoff = ingestReconnectDelay
+	ingest.Unlock()
+	root.logger.Debugw(fmt.Sprintf("linkSource: %s stream linked on ingest %s, passed through %t", mediaType, ingest.id, input == branch.compressed))
+}
+
+// Compressed streams that cannot pass through, an H264 profile browsers do not decode for instance,
+// are decoded here and come back decoded. Decoders go away with the source
+func (ingest *ov3StreamIngest) decodeSource(pad *gst.Pad, mediaType string) {
+	decoder, err := gst.NewElement("decodebin")
+	if err == nil {
+		err = ingest.pipeline.Add(decoder)
+	}
+	if err != nil {
+		root.logger.Errorw(fmt.Sprintf("decodeSource: cannot decode %s stream on ingest %s", mediaType, ingest.id), err)
+		return
+	}
+	decoder.Connect("pad-added", func(element *gst.Element, pad *gst.Pad) {
+		ingest.linkSource(pad)
+	})
+	decoder.SyncStateWithParent()
+	if ret := pad.Link(decoder.GetStaticPad("sink")); ret != gst.PadLinkOK {
+		root.logger.Infow(fmt.Sprintf("decodeSource: cannot link %s stream to decoder on ingest %s: %s", mediaType, ingest.id, ret.String()))
+		decoder.SetState(gst.StateNull)
+		ingest.pipeline.Remove(decoder)
+		return
+	}
+
+	ingest.Lock()
+	ingest.decoders = append(ingest.decoders, decoder)
+	ingest.Unlock()
+}
+
+// Only segment seeks end with segment-done instead of EOS, the first one must flush. It is done once
+// the pipeline prerolled, when the demuxer has exposed all of its streams
+func (ingest *ov3StreamIngest) startLoop() {
+	ingest.Lock()
+	start := ingest.loop && !ingest.looping
+	ingest.looping = ingest.loop
+	ingest.Unlock()
+
+	if start {
+		if err := ingest.seek(0); err != nil {
+			root.logger.Infow(fmt.Sprintf("startLoop: %s", err.Error()))
+		}
+	}
+}
+
+func (ingest *ov3StreamIngest) seekFlags() gst.SeekFlags {
+	if ingest.loop {
+		return gst.SeekFlagFlush | gst.SeekFlagKeyUnit | gst.SeekFlagSegment
+	}
+	return gst.SeekFlagFlush | gst.SeekFlagKeyUnit
+}
+
+func (ingest *ov3StreamIngest) seek(position time.Duration) error {
+	if !ingest.file {
+		return fmt.Errorf("ingest %s is not a file", ingest.id)
+	}
+	if !ingest.pipeline.SeekTime(position, ingest.seekFlags()) {
+		return fmt.Errorf("cannot seek ingest %s to %s", ingest.id, position)
+	}
+	ingest.seeked()
+	return nil
+}
+
+// A file seeked after its end plays again, samples are expected unless it is paused
+func (ingest *ov3StreamIngest) seeked() {
+	ingest.Lock()
+	ingest.ended = false
+	paused := ingest.paused
+	ingest.Unlock()
+
+	if !paused && (ingest.publisher != nil) {
+		ingest.publisher.setIdle(false)
+	}
+}
+
+func (ingest *ov3StreamIngest) setPaused(paused bool) error {
+	if !ingest.file {
+		return fmt.Errorf("ingest %s is not a file", ingest.id)
+	}
+	state := gst.StatePlaying
+	if paused {
+		state = gst.StatePaused
+	}
+	// No samples while paused is not a stall
+	if paused && (ingest.publisher != nil) {
+		ingest.publisher.setIdle(true)
+	}
+	if err := ingest.pipeline.SetState(state); err != nil {
+		return err
+	}
+	ingest.Lock()
+	ingest.paused = paused
+	ended := ingest.ended
+	ingest.Unlock()
+	if !paused && !ended && (ingest.publisher != nil) {
+		ingest.publisher.setIdle(false)
+	}
+	return nil
+}
+
+// Every branch reports its EOS, the event is raised once per end of file. No samples follow until a
+// seek, which is not a stall
+func (ingest *ov3StreamIngest) endOfFile() {
+	ingest.Lock()
+	if ingest.stopped || ingest.ended {
+		ingest.Unlock()
+		return
+	}
+	ingest.ended = true
+	ingest.Unlock()
+
+	if ingest.publisher != nil {
+		ingest.publisher.setIdle(true)
+	}
+
+	emitEvent(ingest.id, "EndOfFile", map[string]interface{}{"ingressId": ingest.ingressId, "loop": false})
+}
+
+// The segment is played again without flushing, the tracks never see the file ending
+func (ingest *ov3StreamIngest) restartLoop() {
+	emitEvent(ingest.id, "EndOfFile", map[string]interface{}{"ingressId": ingest.ingressId, "loop": true})
+	if !ingest.pipeline.SeekTime(0, gst.SeekFlagSegment|gst.SeekFlagKeyUnit) {
+		root.logger.Infow(fmt.Sprintf("restartLoop: cannot loop file on ingest %s", ingest.id))
+	}
+}
+
+// Reconnections back off exponentially while the source keeps failing
+func (ingest *ov3StreamIngest) scheduleReconnect(reason string) {
+	ingest.Lock()
+	defer ingest.Unlock()
+
+	if ingest.stopped || ingest.reconnecting {
+		return
+	}
+	ingest.reconnecting = true
+	ingest.connected = false
+	reason = ingest.redact(reason)
+	ingest.lastError = reason
+	delay := ingest.backoff
+	ingest.backoff = min(2*ingest.backoff, ingestMaxReconnectDelay)
+
+	root.logger.Infow(fmt.Sprintf("scheduleReconnect: ingest %s lost its source (%s), reconnecting in %s", ingest.id, reason, delay))
+	time.AfterFunc(delay, ingest.reconnect)
+}
+
+// The old source is stopped without the ingest lock held, its streaming threads may be waiting on it
+func (ingest *ov3StreamIngest) reconnect() {
+	ingest.Lock()
+	if ingest.stopped {
+		ingest.Unlock()
+		return
+	}
+	old := ingest.source
+	ingest.source = nil
+	decoders := ingest.decoders
+	ingest.decoders = nil
+	ingest.reconnects++
+	ingest.Unlock()
+
+	for _, element := range append([]*gst.Element{old}, decoders...) {
+		if element != nil {
+			element.SetState(gst.StateNull)
+			ingest.pipeline.Remove(element)
+		}
+	}
+
+	ingest.Lock()
+	ingest.reconnecting = false
+	ingest.Unlock()
+
+	if err := ingest.createSource(); err != nil {
+		root.logger.Errorw(fmt.Sprintf("reconnect: cannot create source for ingest %s", ingest.id), errors.New(ingest.redact(err.Error())))
+		ingest.scheduleReconnect(err.Error())
+		return
+	}
+	if err := ingest.pipeline.SetState(gst.StatePlaying); err != nil {
+		ingest.scheduleReconnect(err.Error())
+	}
+}
+
+func (ingest *ov3StreamIngest) watchBus() {
+	bus := ingest.pipeline.GetPipelineBus()
+
+	for {
+		select {
+		case <-ingest.done:
+			return
+		default:
+		}
+
+		msg := bus.TimedPop(gst.ClockTime(ingestBusPoll))
+		if msg == nil {
+			continue
+		}
+		switch msg.Type() {
+		case gst.MessageError:
+			reason := ingest.redact(msg.ParseError().Error())
+			root.logger.Infow(fmt.Sprintf("watchBus: error on ingest %s from %s: %s", ingest.id, msg.Source(), reason))
+			fromSource := ingest.fromSource(msg.Source())
+			if fromSource && !ingest.file {
+				ingest.scheduleReconnect(reason)
+				break
+			}
+			// A broken file will not get better by opening it again, nor a failing encoder or publisher
+			// with a new source
+			ingest.Lock()
+			ingest.lastError = reason
+			ingest.Unlock()
+			emitEvent(ingest.id, "Error", map[string]interface{}{"ingressId": ingest.ingressId, "error": reason})
+			if !fromSource {
+				ingest.stop()
+				return
+			}
+		case gst.MessageAsyncDone:
+			ingest.startLoop()
+		case gst.MessageSegmentDone:
+			ingest.restartLoop()
+		}
+	}
+}
+
+// Errors of the source bin, or of the decoders added for it, are the stream failing. Any other comes
+// from the elements feeding the publisher
+func (ingest *ov3StreamIngest) fromSource(name string) bool {
+	ingest.Lock()
+	elements := append([]*gst.Element{ingest.source}, ingest.decoders...)
+	ingest.Unlock()
+
+	for _, element := range elements {
+		if element == nil {
+			continue
+		}
+		if element.GetName() == name {
+			return true
+		}
+		if _, err := gst.ToGstBin(element).GetElementByName(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (ingest *ov3StreamIngest) stop() {
+	ingest.Lock()
+	if ingest.stopped {
+		ingest.Unlock()
+		return
+	}
+	ingest.stopped = true
+	close(ingest.done)
+	publisher := ingest.publisher
+	ingest.Unlock()
+
+	if publisher != nil {
+		unpublishParticipantImpl(ingest.screenShare, publisher.id)
+	}
+	ingest.pipeline.SetState(gst.StateNull)
+}
+
+// Credentials of the source are not exposed in logs and state
+func (ingest *ov3StreamIngest) redactedUrl() string {
+	redacted, _ := redactUrl(ingest.url)
+	return redacted
+}
+
+// GStreamer errors may quote the source or parts of it
+func (ingest *ov3StreamIngest) redact(text string) string {
+	redacted, secrets := redactUrl(ingest.url)
+	if redacted != "" {
+		text = strings.ReplaceAll(text, ingest.url, redacted)
+	}
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, redactedValue)
+	}
+	return text
+}
+
+// Returns the url with its secrets replaced, and the secrets found: the userinfo password, sensitive query
+// parameters and the RTMP stream key, which is the last element of the path
+func redactUrl(source string) (string, []string) {
+	parsed, err := url.Parse(source)
+	if err != nil {
+		return "", nil
+	}
+
+	var secrets []string
+	if password, ok := parsed.User.Password(); ok && (password != "") {
+		secrets = append(secrets, password)
+		parsed.User = url.UserPassword(parsed.User.Username(), redactedValue)
+	}
+	if parsed.RawQuery != "" {
+		query := parsed.Query()
+		for param, values := range query {
+			if !ingestSensitiveParams[strings.ToLower(param)] {
+				continue
+			}
+			for i, value := range values {
+				if value != "" {
+					secrets = append(secrets, value)
+					values[i] = redactedValue
+				}
+			}
+		}
+		parsed.RawQuery = query.Encode()
+	}
+	if scheme := strings.ToLower(parsed.Scheme); (scheme == "rtmp") || (scheme == "rtmps") {
+		if dir, key := path.Split(parsed.Path); (key != "") && (strings.Trim(dir, "/") != "") {
+			secrets = append(secrets, key)
+			parsed.Path = dir + redactedValue
+			parsed.RawPath = ""
+		}
+	}
+
+	return parsed.String(), secrets
+}
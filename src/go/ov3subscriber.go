@@ -5,13 +5,17 @@ import "C"
 
 // gst-go reelase must be coupled to compiling OS, for ubuntu 2.20, that is gstreamer 1.16, gst-go v0.2.16 seems to be needed
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 	"unsafe"
 
 	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
 
 	"github.com/go-gst/go-glib/glib"
 	"github.com/go-gst/go-gst/gst"
@@ -21,6 +25,7 @@ import (
 	"github.com/livekit/livekit-server/pkg/sfu/buffer"
 	"github.com/livekit/livekit-server/pkg/sfu/codecmunger"
 	"github.com/livekit/protocol/logger"
+	lksdk "github.com/livekit/server-sdk-go/v2"
 )
 
 type state int
@@ -32,8 +37,6 @@ const (
 )
 
 const (
-	latency           = time.Millisecond * 1000
-	drainTimeout      = time.Second * 4
 	errBufferTooSmall = "buffer too small"
 )
 
@@ -50,10 +53,102 @@ type ov3Subscriber struct {
 	audioReady      bool
 	videoReady      bool
 	videoDropping   bool
+	options         subscriberOptions
+	audioFormat     trackFormat
+	videoFormat     trackFormat
+	audioState      mediaState
+	videoState      mediaState
+	audioFiller     *ov3Filler
+	videoFiller     *ov3Filler
+
+	// Timeline of the PTS of this subscriber, correction over it after the clock of its sender jumped, and
+	// offset of its running time over that timeline
+	timelineLock   sync.Mutex
+	timeline       *syncBase
+	timelineOffset int64
+	timelineJoined bool
+	runningOffset  int64
+
+	// Lets the owner of the bins (composites) react to mute and stall changes, called without locks held
+	onMediaState func(kind lksdk.TrackKind, state mediaState)
+
+	rtpEventProbe      uint64
+	jbEventProbe       uint64
+	jbBufferProbe      uint64
+	audioJbBufferProbe uint64
+}
+
+// Codec of the track a chain in the subscriber bin was built for, appsrc caps and depayloader depend on it
+type trackFormat struct {
+	codec       types.MimeType
+	payloadType webrtc.PayloadType
+	clockRate   uint32
+}
+
+func writerFormat(w *AppWriter) trackFormat {
+	return trackFormat{codec: w.codec, payloadType: w.PayloadType, clockRate: w.ClockRate}
+}
+
+func (f trackFormat) String() string {
+	return fmt.Sprintf("%s/%d/%d", f.codec, f.payloadType, f.clockRate)
+}
+
+// Name of the custom downstream event announcing mute and stall changes through the subscriber bins,
+// it carries "kind", "muted" and "stalled" fields
+const mediaStateEvent = "ov3-media-state"
+
+// A muted or stalled track stops sending media without being broken
+type mediaState struct {
+	Muted   bool `json:"muted"`
+	Stalled bool `json:"stalled"`
+}
+
+func (s mediaState) active() bool {
+	return !s.Muted && !s.Stalled
+}
+
+// The event is serialized with the media of the track chain, so elements downstream get it in order
+func (lk *ov3Subscriber) setMediaState(kind lksdk.TrackKind, state mediaState) {
+	var rtpSource *app.Source
+
+	lk.Lock()
+	if kind == lksdk.TrackKindAudio {
+		if lk.audioState == state {
+			lk.Unlock()
+			return
+		}
+		lk.audioState = state
+		rtpSource = lk.audioRtpSource
+	} else {
+		if lk.videoState == state {
+			lk.Unlock()
+			return
+		}
+		lk.videoState = state
+		rtpSource = lk.videoRtpSource
+	}
+	listener := lk.onMediaState
+	filler := lk.getFiller(kind == lksdk.TrackKindAudio)
+	lk.Unlock()
 
-	rtpEventProbe uint64
-	jbEventProbe  uint64
-	jbBufferProbe uint64
+	// Going back to live media is done by the filler itself on the next keyframe
+	if (filler != nil) && !state.active() {
+		go filler.activate()
+	}
+
+	root.logger.Debugw(fmt.Sprintf("setMediaState: %s of subscriber %s muted %t stalled %t", kind, lk.id, state.Muted, state.Stalled))
+	if rtpSource != nil {
+		structure := gst.NewStructure(mediaStateEvent)
+		structure.SetValue("kind", string(kind))
+		structure.SetValue("muted", state.Muted)
+		structure.SetValue("stalled", state.Stalled)
+		if !rtpSource.SendEvent(gst.NewCustomEvent(gst.EventTypeCustomDownstream, structure)) {
+			root.logger.Debugw(fmt.Sprintf("setMediaState: event not accepted by %s chain of subscriber %s", kind, lk.id))
+		}
+	}
+	if listener != nil {
+		listener(kind, state)
+	}
 }
 
 // ******************** Translator
@@ -147,6 +242,15 @@ func exposeSrcInBin(element *gst.Element, bin *gst.Bin) error {
 		return errors.New("cannot get src pad from element")
 	}
 
+	// Chain rebuilt after a codec change, the pad is kept so links made downstream are not lost
+	if existing := bin.GetStaticPad("src"); existing != nil {
+		ghostPad := gst.FromGstGhostPadUnsafeNone(existing.Unsafe())
+		if !ghostPad.SetTarget(pad) {
+			return errors.New("cannot retarget src pad of bin")
+		}
+		return nil
+	}
+
 	ghostPad := gst.NewGhostPad("src", pad)
 	bin.AddPad(ghostPad.ProxyPad.Pad)
 	return nil
@@ -178,7 +282,7 @@ func createAppSrc(caps string, name string) (*app.Source, error) {
 	appSource.SetPropertyValue("format", formatVal)
 	appSource.SetProperty("is-live", true)
 	maxBytesVal, _ := glib.ValueInit(glib.TYPE_INT64)
-	C.g_value_set_int64((*C.GValue)(maxBytesVal.Unsafe()), C.long(2000000))
+	C.g_value_set_int64((*C.GValue)(maxBytesVal.Unsafe()), C.long(getConfig().AppSrcMaxBytes))
 	appSource.SetPropertyValue(("max-bytes"), maxBytesVal)
 	appSource.SetProperty("block", true)
 	appSource.SetProperty("do-timestamp", false)
@@ -191,15 +295,93 @@ func createAppSrc(caps string, name string) (*app.Source, error) {
 	return appSource, nil
 }
 
-func createJitterBuffer(audio bool) (*gst.Element, error) {
-	var latency int
+// rtpjitterbuffer "mode" enum values
+var jitterBufferModes = map[string]int{
+	"none":   0,
+	"slave":  1,
+	"buffer": 2,
+	"synced": 4,
+}
+
+const maxJitterBufferLatency = 10000
+
+type jitterBufferOptions struct {
+	Latency          uint   `json:"latency"`
+	Mode             string `json:"mode"`
+	DoRetransmission bool   `json:"doRetransmission"`
+	DoLost           bool   `json:"doLost"`
+	DropOnLatency    bool   `json:"dropOnLatency"`
+}
+
+// Options given by Kurento on each subscribe request, any field not present keeps its default value
+type subscriberOptions struct {
+	Audio   jitterBufferOptions `json:"audio"`
+	Video   jitterBufferOptions `json:"video"`
+	LipSync bool                `json:"lipSync"`
+	// What is output while the track is muted or in a gap: "frozen" or "black" video plus silence
+	Filler string `json:"filler"`
+}
+
+func defaultSubscriberOptions() subscriberOptions {
+	cfg := getConfig()
+
+	return subscriberOptions{
+		Audio: jitterBufferOptions{
+			Latency:          cfg.AudioLatency,
+			Mode:             "synced",
+			DoRetransmission: true,
+			DoLost:           true,
+			DropOnLatency:    false,
+		},
+		Video: jitterBufferOptions{
+			Latency:          cfg.VideoLatency,
+			Mode:             "synced",
+			DoRetransmission: true,
+			DoLost:           true,
+			DropOnLatency:    false,
+		},
+		LipSync: true,
+	}
+}
+
+func (opts *jitterBufferOptions) validate(desc string) error {
+	if _, ok := jitterBufferModes[opts.Mode]; !ok {
+		return fmt.Errorf("invalid %s jitterbuffer mode %s", desc, opts.Mode)
+	}
+	if opts.Latency > maxJitterBufferLatency {
+		return fmt.Errorf("%s jitterbuffer latency %d ms exceeds %d ms", desc, opts.Latency, maxJitterBufferLatency)
+	}
+	return nil
+}
+
+func parseSubscriberOptions(options string) (subscriberOptions, error) {
+	result := defaultSubscriberOptions()
+
+	if options == "" {
+		return result, nil
+	}
+	if err := json.Unmarshal([]byte(options), &result); err != nil {
+		return result, err
+	}
+	if err := result.Audio.validate("audio"); err != nil {
+		return result, err
+	}
+	if err := result.Video.validate("video"); err != nil {
+		return result, err
+	}
+	if !validFillerMode(result.Filler) {
+		return result, fmt.Errorf("invalid filler %s", result.Filler)
+	}
+
+	return result, nil
+}
+
+func createJitterBuffer(audio bool, opts jitterBufferOptions) (*gst.Element, error) {
 	var jbName string
 
 	if audio {
-		latency = 200
 		jbName = "jitterbuffer_audio"
 	} else {
-		latency = 500
 		jbName = "jitterbuffer_video"
 	}
 
@@ -208,15 +390,15 @@ func createJitterBuffer(audio bool) (*gst.Element, error) {
 		return nil, err
 	}
 
-	if err := jb.SetProperty("do-lost", true); err != nil {
+	if err := jb.SetProperty("do-lost", opts.DoLost); err != nil {
 		return nil, err
 	}
-	if err := jb.SetProperty("do-retransmission", true); err != nil {
+	if err := jb.SetProperty("do-retransmission", opts.DoRetransmission); err != nil {
+		return nil, err
+	}
+	if err := jb.SetProperty("drop-on-latency", opts.DropOnLatency); err != nil {
 		return nil, err
 	}
-	//if err := jb.SetProperty("drop-on-latency", true){
-	//	return nil, err
-	//}
 	if err := jb.SetProperty("faststart-min-packets", uint(1)); err != nil {
 		return nil, err
 	}
@@ -224,35 +406,76 @@ func createJitterBuffer(audio bool) (*gst.Element, error) {
 	// FIXME: Too complicated syntax
 	modePropType, _ := jb.GetPropertyType("mode")
 	modeVal, _ := glib.ValueInit(modePropType)
-	C.g_value_set_enum((*C.GValue)(modeVal.Unsafe()), 4) /*"synced"*/
+	C.g_value_set_enum((*C.GValue)(modeVal.Unsafe()), C.gint(jitterBufferModes[opts.Mode]))
 	if err := jb.SetPropertyValue("mode", modeVal); err != nil {
 		return nil, err
 	}
 	if err := jb.SetProperty("rtx-next-seqnum", false); err != nil {
 		return nil, err
 	}
-	if err := jb.SetProperty("latency", uint(latency)); err != nil {
+	if err := jb.SetProperty("latency", opts.Latency); err != nil {
 		return nil, err
 	}
 
 	return jb, nil
 }
 
-func (lk *ov3Subscriber) prepareTrackGstBin(audio bool, bin *gst.Bin, trackId string, trackMediaCaps string, depayFactory string) (*app.Source, *app.Source, error) {
+// This must be called with subscriber lock held
+func (lk *ov3Subscriber) getFiller(audio bool) *ov3Filler {
+	if audio {
+		return lk.audioFiller
+	}
+	return lk.videoFiller
+}
+
+// The depayloader goes to the bin src pad, through the filler when the subscriber uses one. This must be
+// called with subscriber lock held
+func (lk *ov3Subscriber) exposeTrack(audio bool, bin *gst.Bin, depayloader *gst.Element) error {
+	if lk.options.Filler == fillerNone {
+		return exposeSrcInBin(depayloader, bin)
+	}
+
+	filler := lk.getFiller(audio)
+	if filler == nil {
+		kind := lksdk.TrackKindVideo
+		if audio {
+			kind = lksdk.TrackKindAudio
+		}
+		var err error
+		if filler, err = newFiller(lk, kind, lk.options.Filler, bin); err != nil {
+			return err
+		}
+		if audio {
+			lk.audioFiller = filler
+		} else {
+			lk.videoFiller = filler
+		}
+	}
+	return filler.attach(depayloader)
+}
+
+// RED packets with a non zero redPayloadType are decapsulated before the jitterbuffer, so it sees the
+// packets recovered from redundancy as if they had arrived
+func (lk *ov3Subscriber) prepareTrackGstBin(audio bool, bin *gst.Bin, trackId string, trackMediaCaps string, depayFactory string,
+	redPayloadType webrtc.PayloadType) (*app.Source, *app.Source, error) {
 	var rtcpSource *app.Source
 	var rtpSource *app.Source
+	var redDecoder *gst.Element
 	var jitterBuffer *gst.Element
 	var depayloader *gst.Element
 	var err error
 	var desc string
 	var depayloader_name string
+	var jbOptions jitterBufferOptions
 
 	if audio {
 		desc = "audio"
 		depayloader_name = "depayloader_audio"
+		jbOptions = lk.options.Audio
 	} else {
 		desc = "video"
 		depayloader_name = "depayloader_video"
+		jbOptions = lk.options.Video
 	}
 
 	root.logger.Debugw(fmt.Sprintf("prepareTrackGstBin: Building Gst Bin for %s track %s with media caps %s", desc, trackId, trackMediaCaps))
@@ -261,7 +484,7 @@ func (lk *ov3Subscriber) prepareTrackGstBin(audio bool, bin *gst.Bin, trackId st
 	if err != nil {
 		return nil, nil, err
 	}
-	jitterBuffer, err = createJitterBuffer(audio)
+	jitterBuffer, err = createJitterBuffer(audio, jbOptions)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -282,7 +505,18 @@ func (lk *ov3Subscriber) prepareTrackGstBin(audio bool, bin *gst.Bin, trackId st
 	depayloader.SetProperty("wait-for-keyframe", true)
 
 	bin.AddMany(jitterBuffer, rtcpSource.Element, rtpSource.Element, depayloader)
-	rtpSource.Link(jitterBuffer)
+	if redPayloadType != 0 {
+		if redDecoder, err = gst.NewElementWithName("rtpreddec", "reddec_"+desc); err != nil {
+			return nil, nil, err
+		}
+		redDecoder.SetProperty("pt", int(redPayloadType))
+		bin.Add(redDecoder)
+		rtpSource.Link(redDecoder)
+		redDecoder.Link(jitterBuffer)
+		redDecoder.SyncStateWithParent()
+	} else {
+		rtpSource.Link(jitterBuffer)
+	}
 	rtcpSinkPad := jitterBuffer.GetRequestPad("sink_rtcp")
 	rtcpSrcPad := rtcpSource.GetStaticPad("src")
 	rtcpSrcPad.Link(rtcpSinkPad)
@@ -293,7 +527,7 @@ func (lk *ov3Subscriber) prepareTrackGstBin(audio bool, bin *gst.Bin, trackId st
 	rtpSource.Element.SyncStateWithParent()
 	depayloader.SyncStateWithParent()
 
-	if err = exposeSrcInBin(depayloader, bin); err != nil {
+	if err = lk.exposeTrack(audio, bin, depayloader); err != nil {
 		return nil, nil, err
 	}
 
@@ -301,13 +535,65 @@ func (lk *ov3Subscriber) prepareTrackGstBin(audio bool, bin *gst.Bin, trackId st
 	return rtpSource, rtcpSource, nil
 }
 
+// Probes on the audio chain act on the writer of the current track. This must be called with subscriber lock held
+func (b *ov3Subscriber) addAudioProbes(w *AppWriter) {
+	jb, _ := b.audioBin.GetElementByName("jitterbuffer_audio")
+	if jb != nil {
+		jbSrcPad := jb.GetStaticPad("src")
+		b.audioJbBufferProbe = jbSrcPad.AddProbe(gst.PadProbeTypeBuffer, func(pad *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+			buffer := info.GetBuffer()
+			if buffer != nil {
+				b.DoSynchronize(w, pad, buffer)
+			}
+			return gst.PadProbeOK
+		})
+	}
+}
+
+func (b *ov3Subscriber) removeAudioProbes() {
+	if b.audioBin == nil {
+		return
+	}
+	jb, _ := b.audioBin.GetElementByName("jitterbuffer_audio")
+	if jb != nil {
+		jbSrcPad := jb.GetStaticPad("src")
+		if jbSrcPad != nil {
+			jbSrcPad.RemoveProbe(b.audioJbBufferProbe)
+		}
+	}
+}
+
+// Payload type of the Opus blocks in RED packets, given by the "<pt>/<pt>..." fmtp line of RFC 2198
+func redPrimaryPayloadType(fmtp string) (webrtc.PayloadType, error) {
+	blocks := strings.Split(strings.TrimSpace(fmtp), "/")
+	pt, err := strconv.ParseUint(blocks[0], 10, 7)
+	if err != nil {
+		return 0, fmt.Errorf("invalid RED fmtp %q", fmtp)
+	}
+	for _, block := range blocks[1:] {
+		if block != blocks[0] {
+			return 0, fmt.Errorf("RED with mixed payloads %q is not supported", fmtp)
+		}
+	}
+	return webrtc.PayloadType(pt), nil
+}
+
 // This must be called with subscriber lock held
 func (b *ov3Subscriber) addAudioAppSrcBin(w *AppWriter) error {
 	var trackMediaCaps string
 	var depayFactory string
+	var redPayloadType webrtc.PayloadType
 
 	if b.audioRtpSource != nil {
-		return nil
+		if b.audioFormat == writerFormat(w) {
+			// Track replaced by one with the same codec, the chain is kept and only the writer changes
+			b.removeAudioProbes()
+			b.addAudioProbes(w)
+			return nil
+		}
+		root.logger.Infow(fmt.Sprintf("addAudioAppSrcBin: audio format changed from %s to %s on subscriber %s, rebuilding",
+			b.audioFormat, writerFormat(w), b.id))
+		b.removeTrackGstBin(true)
 	}
 
 	appSrcBin := b.audioBin
@@ -317,73 +603,47 @@ func (b *ov3Subscriber) addAudioAppSrcBin(w *AppWriter) error {
 		trackMediaCaps = fmt.Sprintf("application/x-rtp,media=audio,payload=%d,encoding-name=OPUS,clock-rate=%d",
 			w.PayloadType, w.ClockRate)
 		depayFactory = "rtpopusdepay"
+	case mimeTypeRED:
+		// Caps are the ones of the Opus packets that come out of the RED decoder
+		opusPayloadType, err := redPrimaryPayloadType(w.track.Codec().SDPFmtpLine)
+		if err != nil {
+			return err
+		}
+		trackMediaCaps = fmt.Sprintf("application/x-rtp,media=audio,payload=%d,encoding-name=OPUS,clock-rate=%d",
+			opusPayloadType, w.ClockRate)
+		depayFactory = "rtpopusdepay"
+		redPayloadType = w.PayloadType
+	case mimeTypePCMU:
+		trackMediaCaps = fmt.Sprintf("application/x-rtp,media=audio,payload=%d,encoding-name=PCMU,clock-rate=%d",
+			w.PayloadType, w.ClockRate)
+		depayFactory = "rtppcmudepay"
+	case mimeTypePCMA:
+		trackMediaCaps = fmt.Sprintf("application/x-rtp,media=audio,payload=%d,encoding-name=PCMA,clock-rate=%d",
+			w.PayloadType, w.ClockRate)
+		depayFactory = "rtppcmadepay"
 	default:
 		return fmt.Errorf("%s is not yet supported", w.codec)
 	}
 
 	// FIXME:: This should be done on ov3Subscription to use one single ingress pipeline and then share the output to all subscribers
-	rtpSource, rtcpSource, err := b.prepareTrackGstBin(true, appSrcBin, w.track.ID(), trackMediaCaps, depayFactory)
+	rtpSource, rtcpSource, err := b.prepareTrackGstBin(true, appSrcBin, w.track.ID(), trackMediaCaps, depayFactory, redPayloadType)
 	if err != nil {
 		return err
 	}
 	b.audioRtpSource = rtpSource
 	b.audioRtcpSource = rtcpSource
+	b.audioFormat = writerFormat(w)
+
+	b.addAudioProbes(w)
 
 	root.logger.Debugw(fmt.Sprintf("addAudioAppSrcBin: created %s audio bin for track %s and subcriber %s", w.codec, w.pub.SID(), b.id))
 	return nil
 }
 
-func (lk *ov3Subscriber) DoSynchronize(buffer *gst.Buffer) {
-	// FIXME: On upgrading to GStreamer 1.22 we should set the property of rtpjitterbuffer 'add-reference-timestamp-meta'
-	// That will allow to set on the buffer a GstReferenceTimestampMeta  with timing information got both from RTCP SR or inband
-	// NTP-64 header (that is used in webrtc and specifically in livekit) to provide better synchronization
-	// When that meta is available, we should get the meta, extract timing info (https://gstreamer.freedesktop.org/documentation/gstreamer/gstbuffer.html#GstReferenceTimestampMeta)
-	// and set the PTS of buffers according to that
-
-	//bufferPts := buffer.PresentationTimestamp()
-
-}
-
-// This must be called with subscriber lock held
-func (lk *ov3Subscriber) addVideoAppSrcBin(w *AppWriter) error {
-	var trackMediaCaps string
-	var depayFactory string
-
-	if lk.videoRtpSource != nil {
-		return nil
-	}
-
-	appSrcBin := lk.videoBin
-
-	switch w.codec {
-	case types.MimeTypeH264:
-		trackMediaCaps = fmt.Sprintf("application/x-rtp,media=video,payload=%d,encoding-name=H264,clock-rate=%d",
-			w.PayloadType, w.ClockRate)
-		depayFactory = "rtph264depay"
-
-	case types.MimeTypeVP8:
-		trackMediaCaps = fmt.Sprintf("application/x-rtp,media=video,payload=%d,encoding-name=VP8,clock-rate=%d",
-			w.PayloadType, w.ClockRate)
-		depayFactory = "rtpvp8depay"
-
-	case types.MimeTypeVP9:
-		trackMediaCaps = fmt.Sprintf("application/x-rtp,media=video,payload=%d,encoding-name=VP9,clock-rate=%d",
-			w.PayloadType, w.ClockRate)
-		depayFactory = "rtpvp9depay"
-
-	default:
-		return fmt.Errorf("%s is not yet supported", w.codec)
-	}
-
-	// FIXME:: This should be done on ov3Subscription to use one single ingress pipeline and then share the output to all subscribers
-	rtpSource, rtcpSource, err := lk.prepareTrackGstBin(false, appSrcBin, w.track.ID(), trackMediaCaps, depayFactory)
-	if err != nil {
-		return err
-	}
-	lk.videoRtpSource = rtpSource
-	lk.videoRtcpSource = rtcpSource
-
-	srcPad := rtpSource.Element.GetStaticPad("src")
+// Probes on the video chain act on the writer of the current track. This must be called with subscriber lock held
+func (lk *ov3Subscriber) addVideoProbes(w *AppWriter) {
+	filler := lk.videoFiller
+	srcPad := lk.videoRtpSource.Element.GetStaticPad("src")
 	lk.rtpEventProbe = srcPad.AddProbe(gst.PadProbeTypeEventUpstream, func(pad *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
 		if !lk.videoReady {
 			return gst.PadProbeDrop
@@ -437,7 +697,7 @@ func (lk *ov3Subscriber) addVideoAppSrcBin(w *AppWriter) error {
 				}
 			}
 
-			lk.DoSynchronize(buffer)
+			lk.DoSynchronize(w, pad, buffer)
 			return gst.PadProbeOK
 		})
 		lk.jbEventProbe = jbSrcPad.AddProbe(gst.PadProbeTypeEventDownstream, func(pad *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
@@ -453,21 +713,19 @@ func (lk *ov3Subscriber) addVideoAppSrcBin(w *AppWriter) error {
 			if event.HasName("GstRTPPacketLost") {
 				root.logger.Debugw(fmt.Sprintf("AppSrc Pad probe: Packet lost, requesting PLI on track %s", w.pub.SID()))
 				w.EnterInGap()
+				// The depayloader drops until the next keyframe
+				if filler != nil {
+					go filler.activate()
+				}
 				return gst.PadProbeDrop
 			}
 
 			return gst.PadProbeOK
 		})
 	}
-
-	root.logger.Debugw(fmt.Sprintf("addVideoAppSrcBin: created %s video bin for track %s and subcriber %s", w.codec, w.pub.SID(), lk.id))
-	return nil
 }
 
-func (lk *ov3Subscriber) DestroySubscriber() {
-	lk.Lock()
-	defer lk.Unlock()
-
+func (lk *ov3Subscriber) removeVideoProbes() {
 	rtpSource := lk.videoRtpSource
 	if rtpSource != nil {
 		srcPad := rtpSource.Element.GetStaticPad("src")
@@ -487,7 +745,143 @@ func (lk *ov3Subscriber) DestroySubscriber() {
 			}
 		}
 	}
+}
+
+// This must be called with subscriber lock held
+func (lk *ov3Subscriber) addVideoAppSrcBin(w *AppWriter) error {
+	var trackMediaCaps string
+	var depayFactory string
+
+	if lk.videoRtpSource != nil {
+		if lk.videoFormat == writerFormat(w) {
+			// Track replaced by one with the same codec, the chain is kept and only the writer changes
+			lk.removeVideoProbes()
+			lk.addVideoProbes(w)
+			return nil
+		}
+		root.logger.Infow(fmt.Sprintf("addVideoAppSrcBin: video format changed from %s to %s on subscriber %s, rebuilding",
+			lk.videoFormat, writerFormat(w), lk.id))
+		lk.removeTrackGstBin(false)
+	}
+
+	appSrcBin := lk.videoBin
+
+	switch w.codec {
+	case types.MimeTypeH264:
+		trackMediaCaps = fmt.Sprintf("application/x-rtp,media=video,payload=%d,encoding-name=H264,clock-rate=%d",
+			w.PayloadType, w.ClockRate)
+		depayFactory = "rtph264depay"
+
+	case types.MimeTypeVP8:
+		trackMediaCaps = fmt.Sprintf("application/x-rtp,media=video,payload=%d,encoding-name=VP8,clock-rate=%d",
+			w.PayloadType, w.ClockRate)
+		depayFactory = "rtpvp8depay"
+
+	case types.MimeTypeVP9:
+		trackMediaCaps = fmt.Sprintf("application/x-rtp,media=video,payload=%d,encoding-name=VP9,clock-rate=%d",
+			w.PayloadType, w.ClockRate)
+		depayFactory = "rtpvp9depay"
+
+	default:
+		return fmt.Errorf("%s is not yet supported", w.codec)
+	}
+
+	// FIXME:: This should be done on ov3Subscription to use one single ingress pipeline and then share the output to all subscribers
+	rtpSource, rtcpSource, err := lk.prepareTrackGstBin(false, appSrcBin, w.track.ID(), trackMediaCaps, depayFactory, 0)
+	if err != nil {
+		return err
+	}
+	lk.videoRtpSource = rtpSource
+	lk.videoRtcpSource = rtcpSource
+	lk.videoFormat = writerFormat(w)
+
+	lk.addVideoProbes(w)
+
+	root.logger.Debugw(fmt.Sprintf("addVideoAppSrcBin: created %s video bin for track %s and subcriber %s", w.codec, w.pub.SID(), lk.id))
+	return nil
+}
+
+// Tears down the appsrc, jitterbuffer and depayloader of a track so a chain for a different codec can be
+// built in the same bin. The src pad of the bin stays linked, downstream is flushed and then receives
+// stream-start and the new caps from the new depayloader, so the endpoint renegotiates instead of
+// stalling on caps it cannot accept. This must be called with subscriber lock held
+func (lk *ov3Subscriber) removeTrackGstBin(audio bool) {
+	var bin *gst.Bin
+	var rtpSource *app.Source
+	var rtcpSource *app.Source
+	var desc string
+
+	if audio {
+		desc = "audio"
+		bin = lk.audioBin
+		rtpSource = lk.audioRtpSource
+		rtcpSource = lk.audioRtcpSource
+		lk.audioReady = false
+		lk.removeAudioProbes()
+	} else {
+		desc = "video"
+		bin = lk.videoBin
+		rtpSource = lk.videoRtpSource
+		rtcpSource = lk.videoRtcpSource
+		lk.videoReady = false
+		lk.removeVideoProbes()
+	}
+	if bin == nil {
+		return
+	}
+
+	jb, _ := bin.GetElementByName("jitterbuffer_" + desc)
+	depayloader, _ := bin.GetElementByName("depayloader_" + desc)
+	redDecoder, _ := bin.GetElementByName("reddec_" + desc)
+
+	elements := []*gst.Element{jb, depayloader, redDecoder}
+	if rtpSource != nil {
+		elements = append(elements, rtpSource.Element)
+	}
+	if rtcpSource != nil {
+		elements = append(elements, rtcpSource.Element)
+	}
+	for _, element := range elements {
+		if element == nil {
+			continue
+		}
+		element.SetState(gst.StateNull)
+		bin.Remove(element)
+	}
+
+	// Nothing from the old track may be waiting downstream when the caps change
+	if pad := bin.GetStaticPad("src"); pad != nil {
+		pad.PushEvent(gst.NewFlushStartEvent())
+		pad.PushEvent(gst.NewFlushStopEvent(true))
+	}
+
+	if audio {
+		lk.audioRtpSource = nil
+		lk.audioRtcpSource = nil
+		lk.audioFormat = trackFormat{}
+	} else {
+		lk.videoRtpSource = nil
+		lk.videoRtcpSource = nil
+		lk.videoFormat = trackFormat{}
+	}
+
+	root.logger.Debugw(fmt.Sprintf("removeTrackGstBin: removed %s chain from subscriber %s", desc, lk.id))
+}
+
+func (lk *ov3Subscriber) DestroySubscriber() {
+	lk.Lock()
+	defer lk.Unlock()
+
+	lk.removeVideoProbes()
+	lk.removeAudioProbes()
+	for _, filler := range []*ov3Filler{lk.audioFiller, lk.videoFiller} {
+		if filler != nil {
+			filler.destroy()
+		}
+	}
 
+	lk.audioFiller = nil
+	lk.videoFiller = nil
 	lk.audioRtpSource = nil
 	lk.audioRtcpSource = nil
 	lk.audioReady = false
@@ -16,6 +16,14 @@ import (
 	"github.com/pion/webrtc/v4"
 )
 
+// Codecs the SFU may negotiate that are not known by egress types. RED is redundant audio (RFC 2198)
+// negotiated by LiveKit for Opus microphone tracks, G.711 is only allowed when enabled in the server
+const (
+	mimeTypeRED  types.MimeType = "audio/red"
+	mimeTypePCMU types.MimeType = "audio/pcmu"
+	mimeTypePCMA types.MimeType = "audio/pcma"
+)
+
 type ov3Subscription struct {
 	sync.RWMutex
 	room          *ov3Room
@@ -27,6 +35,11 @@ type ov3Subscription struct {
 	audioTrack    *lkTrack
 	videoTrack    *lkTrack
 	subscribers   []*ov3Subscriber
+	lipSync       ov3LipSync
+
+	// Writers of the tracks that replace the current ones continue their RTP streams
+	audioContinuity rtpContinuity
+	videoContinuity rtpContinuity
 }
 
 type lkTrack struct {
@@ -38,6 +51,91 @@ type lkTrack struct {
 	subscribed   bool
 }
 
+func (subs *ov3Subscription) continuity(kind lksdk.TrackKind) *rtpContinuity {
+	if kind == lksdk.TrackKindAudio {
+		return &subs.audioContinuity
+	}
+	return &subs.videoContinuity
+}
+
+// Time a missing packet is waited for, the longest jitterbuffer latency of the subscribers of a kind
+func (subs *ov3Subscription) retransmissionDeadline(kind lksdk.TrackKind) time.Duration {
+	latencyOf := func(options subscriberOptions) uint {
+		if kind == lksdk.TrackKindAudio {
+			return options.Audio.Latency
+		}
+		return options.Video.Latency
+	}
+
+	subs.RLock()
+	defer subs.RUnlock()
+	if len(subs.subscribers) == 0 {
+		return time.Duration(latencyOf(defaultSubscriberOptions())) * time.Millisecond
+	}
+	var latency uint
+	for _, subscriber := range subs.subscribers {
+		if l := latencyOf(subscriber.options); l > latency {
+			latency = l
+		}
+	}
+	return time.Duration(latency) * time.Millisecond
+}
+
+// True if some subscriber has a bin for the kind, tracks of other kinds are not subscribed (audio mixer
+// inputs do not download video, composite inputs do not download audio)
+func (subs *ov3Subscription) wantsKind(kind lksdk.TrackKind) bool {
+	subs.RLock()
+	defer subs.RUnlock()
+
+	for _, subscriber := range subs.subscribers {
+		if (kind == lksdk.TrackKindAudio) && (subscriber.audioBin != nil) {
+			return true
+		}
+		if (kind == lksdk.TrackKindVideo) && (subscriber.videoBin != nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ends the writer of a kind if it still belongs to the given track, a replacement may already be
+// attached. Must be called with subscription lock held
+func (subs *ov3Subscription) endWriter(kind lksdk.TrackKind, trackSid string) bool {
+	var writer *AppWriter
+
+	if kind == lksdk.TrackKindAudio {
+		writer = subs.audioWriter
+	} else if kind == lksdk.TrackKindVideo {
+		writer = subs.videoWriter
+	}
+	if (writer == nil) || (writer.pub.SID() != trackSid) {
+		return false
+	}
+
+	writer.endStream.Break()
+	if kind == lksdk.TrackKindAudio {
+		subs.audioWriter = nil
+	} else {
+		subs.videoWriter = nil
+	}
+	return true
+}
+
+// Forgets an unpublished track, so the next one of the same kind published by the participant replaces it
+// (camera or microphone toggled). Subscriber bins are kept and the writer of the new track is attached
+// to them
+func (subs *ov3Subscription) releaseTrack(pub *lksdk.RemoteTrackPublication) {
+	subs.Lock()
+	defer subs.Unlock()
+
+	subs.endWriter(pub.Kind(), pub.SID())
+	if (pub.Kind() == lksdk.TrackKindAudio) && (subs.audioTrack != nil) && (subs.audioTrack.trackId == pub.SID()) {
+		subs.audioTrack = nil
+	} else if (pub.Kind() == lksdk.TrackKindVideo) && (subs.videoTrack != nil) && (subs.videoTrack.trackId == pub.SID()) {
+		subs.videoTrack = nil
+	}
+}
+
 func (subs *ov3Subscription) removeSubscription() {
 	for _, subscriber := range subs.subscribers {
 		unsubscribeParticipantImpl(subscriber.id)
@@ -47,18 +145,33 @@ func (subs *ov3Subscription) removeSubscription() {
 // Must be called with subscription Logck held
 func (subs *ov3Subscription) createWriter(track *webrtc.TrackRemote, pub *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) error {
 	trackKind := pub.Kind()
+	replacing := false
 	if trackKind == "audio" {
 		if subs.audioWriter != nil {
-			root.logger.Infow(fmt.Sprintf("createWriter: audio writer already created for track %s", pub.SID()))
-			return nil
+			if subs.audioWriter.pub.SID() == pub.SID() {
+				root.logger.Infow(fmt.Sprintf("createWriter: audio writer already created for track %s", pub.SID()))
+				return nil
+			}
+			root.logger.Infow(fmt.Sprintf("createWriter: audio track %s replaces %s", pub.SID(), subs.audioWriter.pub.SID()))
+			subs.endWriter(trackKind, subs.audioWriter.pub.SID())
 		}
+		replacing = subs.audioContinuity.started()
 	} else if trackKind == "video" {
 		if subs.videoWriter != nil {
-			root.logger.Infow(fmt.Sprintf("createWriter: video writer already created for track %s", pub.SID()))
-			return nil
+			if subs.videoWriter.pub.SID() == pub.SID() {
+				root.logger.Infow(fmt.Sprintf("createWriter: video writer already created for track %s", pub.SID()))
+				return nil
+			}
+			root.logger.Infow(fmt.Sprintf("createWriter: video track %s replaces %s", pub.SID(), subs.videoWriter.pub.SID()))
+			subs.endWriter(trackKind, subs.videoWriter.pub.SID())
 		}
+		replacing = subs.videoContinuity.started()
 	}
 
+	// Sender reports of a previous track are not valid for the new one
+	subs.lipSync.reset(trackKind)
+
+	cfg := getConfig()
 	root.logger.Infow(fmt.Sprintf("createWriter: creating %s writer for track %s, SSRC (%d)", pub.Kind(), pub.SID(), track.SSRC()))
 	w := &AppWriter{
 		logger:       root.logger, //logger.GetLogger().WithValues("trackID", track.ID(), "kind", track.Kind().String()),
@@ -72,11 +185,21 @@ func (subs *ov3Subscription) createWriter(track *webrtc.TrackRemote, pub *lksdk.
 		nullSamples:  0,
 		lastPLI:      time.Now().Add(-10 * time.Second), // First PLI should not be stopped by rate limiter
 		dropping:     false,
-		retransmit:   make(chan uint16, 20),
+		nack:         newNackScheduler(int(cfg.RetransmitQueue)),
 		rtx:          true,
 	}
 
+	if receiver := pub.Receiver(); receiver != nil {
+		w.rtxPayloadType = rtxPayloadTypeFor(receiver.GetParameters().Codecs, w.PayloadType)
+		if w.rtxPayloadType != 0 {
+			root.logger.Debugw(fmt.Sprintf("createWriter: retransmissions of track %s on payload type %d", pub.SID(), w.rtxPayloadType))
+		}
+	}
+
 	pub.OnRTCP(func(pkt rtcp.Packet) {
+		if xr, ok := pkt.(*rtcp.ExtendedReport); ok {
+			w.nack.receivedXR(xr, time.Now())
+		}
 		for _, ssrc := range pkt.DestinationSSRC() {
 			if ssrc == uint32(track.SSRC()) {
 				/*root.logger.Debugw(fmt.Sprintf("Writer.onRTCP: received RTCP %T for %d on track %s with SSRC (%d)", pkt, ssrc,
@@ -87,7 +210,7 @@ func (subs *ov3Subscription) createWriter(track *webrtc.TrackRemote, pub *lksdk.
 	})
 
 	switch w.codec {
-	case types.MimeTypeOpus:
+	case types.MimeTypeOpus, mimeTypeRED, mimeTypePCMU, mimeTypePCMA:
 		w.translator = NewNullTranslator()
 		w.validSamples = 0
 		w.forceSendPLI = nil
@@ -98,6 +221,7 @@ func (subs *ov3Subscription) createWriter(track *webrtc.TrackRemote, pub *lksdk.
 			root.logger.Debugw(fmt.Sprintf("H264 sendPLI %s", w.pub.SID()))
 			rp.WritePLI(track.SSRC())
 			w.lastPLI = time.Now()
+			w.stats.plis.Add(1)
 		}
 		w.validSamples = 0
 
@@ -107,6 +231,7 @@ func (subs *ov3Subscription) createWriter(track *webrtc.TrackRemote, pub *lksdk.
 			root.logger.Debugw(fmt.Sprintf("VP8 sendPLI %s", w.pub.SID()))
 			rp.WritePLI(track.SSRC())
 			w.lastPLI = time.Now()
+			w.stats.plis.Add(1)
 		}
 		w.validSamples = 0
 
@@ -116,6 +241,7 @@ func (subs *ov3Subscription) createWriter(track *webrtc.TrackRemote, pub *lksdk.
 			root.logger.Debugw(fmt.Sprintf("VP9 sendPLI %s", w.pub.SID()))
 			rp.WritePLI(track.SSRC())
 			w.lastPLI = time.Now()
+			w.stats.plis.Add(1)
 		}
 		w.validSamples = 0
 
@@ -123,8 +249,8 @@ func (subs *ov3Subscription) createWriter(track *webrtc.TrackRemote, pub *lksdk.
 		return fmt.Errorf("%s is not yet supported", w.codec)
 	}
 
-	if debug {
-		f, err := os.Create(logPath + "/" + w.pub.SID() + ".pts.log")
+	if cfg.PtsLog {
+		f, err := os.Create(cfg.LogsPath + "/" + w.pub.SID() + ".pts.log")
 		if err != nil {
 			return err
 		}
@@ -138,33 +264,46 @@ func (subs *ov3Subscription) createWriter(track *webrtc.TrackRemote, pub *lksdk.
 		if subs.audioWriter != nil {
 			return fmt.Errorf("audio writer already created for track %s", pub.SID())
 		}
-		if subs.audioTrack == nil {
+		if (subs.audioTrack == nil) || (subs.audioTrack.trackId != pub.SID()) {
 			subs.audioTrack = subs.makeTrack(pub)
 		}
 		subs.audioWriter = w
 		root.logger.Debugw(fmt.Sprintf("createWriter: created audio writer for track %s", pub.SID()))
 		for _, subscriber := range subs.subscribers {
-			// FIXME get and log error condition
+			if subscriber.audioBin == nil {
+				continue
+			}
 			subscriber.Lock()
-			subscriber.addAudioAppSrcBin(w)
-			subscriber.audioReady = true
+			if err := subscriber.addAudioAppSrcBin(w); err != nil {
+				root.logger.Errorw(fmt.Sprintf("createWriter: cannot attach audio track %s to subscriber %s", pub.SID(), subscriber.id), err)
+			} else {
+				subscriber.audioReady = true
+			}
 			subscriber.Unlock()
+			// A replaced track may have left the subscriber muted
+			subscriber.setMediaState(w.kind, w.mediaState())
 		}
 	} else if w.kind == "video" {
 		if subs.videoWriter != nil {
 			return fmt.Errorf("video writer already created for track %s", pub.SID())
 		}
-		if subs.videoTrack == nil {
+		if (subs.videoTrack == nil) || (subs.videoTrack.trackId != pub.SID()) {
 			subs.videoTrack = subs.makeTrack(pub)
 		}
 		subs.videoWriter = w
 		root.logger.Debugw(fmt.Sprintf("createWriter: created video writer for track %s", pub.SID()))
 		for _, subscriber := range subs.subscribers {
-			// FIXME get and log error condition
-			subscriber.addVideoAppSrcBin(w)
+			if subscriber.videoBin == nil {
+				continue
+			}
 			subscriber.Lock()
-			subscriber.videoReady = true
+			if err := subscriber.addVideoAppSrcBin(w); err != nil {
+				root.logger.Errorw(fmt.Sprintf("createWriter: cannot attach video track %s to subscriber %s", pub.SID(), subscriber.id), err)
+			} else {
+				subscriber.videoReady = true
+			}
 			subscriber.Unlock()
+			subscriber.setMediaState(w.kind, w.mediaState())
 		}
 	} else {
 		return fmt.Errorf("invalid track kind %s", w.kind)
@@ -172,6 +311,11 @@ func (subs *ov3Subscription) createWriter(track *webrtc.TrackRemote, pub *lksdk.
 
 	w.state = statePlaying
 
+	// Decoders downstream keep the state of the previous track, the replacement must start on a keyframe
+	if replacing {
+		w.sendPLI()
+	}
+
 	go w.run()
 
 	go w.RetransmissionsTask()
@@ -206,7 +350,7 @@ func (lk *ov3Subscription) unsubscribe(track *lksdk.RemoteTrackPublication) erro
 	return err
 }
 
-func (subs *ov3Subscription) addSubscriber(audioBin *gst.Bin, videoBin *gst.Bin) *ov3Subscriber {
+func (subs *ov3Subscription) addSubscriber(audioBin *gst.Bin, videoBin *gst.Bin, options subscriberOptions) *ov3Subscriber {
 	subs.Lock()
 	subscriber := ov3Subscriber{}
 	subscriber.subscription = subs
@@ -218,6 +362,12 @@ func (subs *ov3Subscription) addSubscriber(audioBin *gst.Bin, videoBin *gst.Bin)
 	subscriber.audioReady = false
 	subscriber.videoReady = false
 	subscriber.videoDropping = false
+	subscriber.options = options
+	if subs.room.aligned {
+		subscriber.timeline = &subs.room.timeline
+	} else {
+		subscriber.timeline = &syncBase{}
+	}
 
 	subs.subscribers = append(subs.subscribers, &subscriber)
 
@@ -228,19 +378,28 @@ func (subs *ov3Subscription) addSubscriber(audioBin *gst.Bin, videoBin *gst.Bin)
 
 func (subs *ov3Subscription) buildSubscriber(subscriber *ov3Subscriber) {
 	subscriber.Lock()
-	defer subscriber.Unlock()
-	if subs.audioWriter != nil {
+	// Subscribers without a bin for one of the kinds (audio mixer inputs) just ignore that track
+	if (subs.audioWriter != nil) && (subscriber.audioBin != nil) {
 		if !subscriber.audioReady {
 			subscriber.addAudioAppSrcBin(subs.audioWriter)
 			subscriber.audioReady = true
 		}
 	}
-	if subs.videoWriter != nil {
+	if (subs.videoWriter != nil) && (subscriber.videoBin != nil) {
 		if !subscriber.videoReady {
 			subscriber.addVideoAppSrcBin(subs.videoWriter)
 			subscriber.videoReady = true
 		}
 	}
+	subscriber.Unlock()
+
+	// Tracks muted before the subscriber joined
+	if subs.audioWriter != nil {
+		subscriber.setMediaState(lksdk.TrackKindAudio, subs.audioWriter.mediaState())
+	}
+	if subs.videoWriter != nil {
+		subscriber.setMediaState(lksdk.TrackKindVideo, subs.videoWriter.mediaState())
+	}
 }
 
 func removeElement(array []*ov3Subscriber, indexes []int) []*ov3Subscriber {
@@ -264,7 +423,7 @@ func (subs *ov3Subscription) removeSubscriber(id string) {
 			aux := make([]int, 0)
 			aux = append(aux, i)
 			indexes = append(aux, indexes...)
-			delete(root.subscribers, id)
+			root.deleteSubscriber(id)
 		}
 	}
 	subs.subscribers = removeElement(subs.subscribers, indexes)
@@ -276,31 +435,38 @@ func (subs *ov3Subscription) removeSubscriber(id string) {
 		subs.room.removeSubscription(subs.participant, subs.isScreenShare)
 	} else {
 		subs.Unlock()
+		subs.releaseUnwantedKinds()
 	}
 }
 
-func (lk *ov3Subscription) doSubscribe(track *lkTrack) {
-	if track.track == nil {
-		root.logger.Infow(fmt.Sprintf("doSubscribe: no track to subscribe on %s", track.trackId))
-		return
-	}
-	err := lk.subscribe(track.track)
-	if err != nil {
-		root.logger.Infow(fmt.Sprintf("doSubscribe: could not subscribe to track %s", track.trackId))
-	} else {
-		root.addSubscribedTrack(track.trackId, lk)
-	}
-}
+// Unsubscribes the tracks of kinds no remaining subscriber has a bin for
+func (subs *ov3Subscription) releaseUnwantedKinds() {
+	for _, kind := range []lksdk.TrackKind{lksdk.TrackKindAudio, lksdk.TrackKindVideo} {
+		if subs.wantsKind(kind) {
+			continue
+		}
+		subs.Lock()
+		track := subs.videoTrack
+		if kind == lksdk.TrackKindAudio {
+			track = subs.audioTrack
+		}
+		if track == nil {
+			subs.Unlock()
+			continue
+		}
+		subs.endWriter(kind, track.trackId)
+		root.removeSubscribedTrack(track.trackId)
+		if kind == lksdk.TrackKindAudio {
+			subs.audioTrack = nil
+		} else {
+			subs.videoTrack = nil
+		}
+		subs.Unlock()
 
-func (lk *ov3Subscription) subscribeToParticipant() {
-	// We have already looked for the subscriptions to be made, so no decission is needed now at that respect.
-	audioTrack := lk.audioTrack
-	if audioTrack != nil {
-		lk.doSubscribe(audioTrack)
-	}
-	videoTrack := lk.videoTrack
-	if videoTrack != nil {
-		lk.doSubscribe(videoTrack)
+		root.logger.Debugw(fmt.Sprintf("releaseUnwantedKinds: no subscriber left for %s track %s of %s", kind, track.trackId, subs.participant))
+		if track.track != nil {
+			subs.unsubscribe(track.track)
+		}
 	}
 }
 
@@ -391,7 +557,7 @@ func (lk *ov3Subscription) checkTracksToSubscribe(p *lksdk.RemoteParticipant) {
 				source := pub.Source()
 				isScreenShare := (source == livekit.TrackSource_SCREEN_SHARE) || (source == livekit.TrackSource_SCREEN_SHARE_AUDIO)
 				isNotScreenShare := (source == livekit.TrackSource_CAMERA) || (source == livekit.TrackSource_MICROPHONE)
-				if (lk.isScreenShare && isScreenShare) || (!lk.isScreenShare && isNotScreenShare) {
+				if ((lk.isScreenShare && isScreenShare) || (!lk.isScreenShare && isNotScreenShare)) && lk.wantsKind(pub.Kind()) {
 					root.logger.Debugw(fmt.Sprintf("checkTracksToSubscribe: subscribing to track %s", track.SID()))
 
 					tr := lk.makeTrack(pub)
@@ -441,7 +607,7 @@ func (lk *ov3Subscription) makeSubscription() {
 	for _, p := range room.roomSvc.GetRemoteParticipants() {
 		if p.Identity() == lk.participant {
 			root.logger.Debugw(fmt.Sprintf("makeSubscription: checking existent tracks to subscribe for participant %s", lk.participant))
-			lk.checkTracksToSubscribe(p)
+			room.checkTrackSubscription(lk, p)
 		}
 	}
 }
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// Timestamps computed from sender reports further than this from the jitterbuffer ones are not trusted,
+// the timeline is rebased instead (publisher clock jump, SSRC change, ...)
+const maxSyncCorrection = uint64(2 * time.Second)
+
+// Mapping between the RTP timestamps of a track and the NTP wallclock of its sender, as reported on the
+// last RTCP sender report
+type srMapping struct {
+	valid     bool
+	ntpTime   int64 // nanoseconds since NTP epoch
+	rtpTime   uint32
+	clockRate uint32
+}
+
+// Sender report data of a subscription. Audio and video tracks of a participant share the sender
+// wallclock, so it gives a common timebase for both.
+type ov3LipSync struct {
+	sync.RWMutex
+	audio srMapping
+	video srMapping
+}
+
+// Base of the output timeline of a subscriber, set by the first synchronized buffer of any of its tracks.
+// It is shared by all subscribers of a room in aligned mode, and never moved afterwards.
+type syncBase struct {
+	sync.Mutex
+	set     bool
+	ntpTime int64
+	pts     uint64
+}
+
+func ntpToNanos(ntp uint64) int64 {
+	secs := ntp >> 32
+	frac := ntp & 0xffffffff
+	return int64(secs)*1000000000 + int64((frac*1000000000)>>32)
+}
+
+func (ls *ov3LipSync) updateSR(kind lksdk.TrackKind, sr *rtcp.SenderReport, clockRate uint32) {
+	if clockRate == 0 {
+		return
+	}
+	mapping := srMapping{
+		valid:     true,
+		ntpTime:   ntpToNanos(sr.NTPTime),
+		rtpTime:   sr.RTPTime,
+		clockRate: clockRate,
+	}
+
+	ls.Lock()
+	defer ls.Unlock()
+	if kind == lksdk.TrackKindAudio {
+		ls.audio = mapping
+	} else if kind == lksdk.TrackKindVideo {
+		ls.video = mapping
+	}
+}
+
+func (ls *ov3LipSync) mapping(kind lksdk.TrackKind) srMapping {
+	ls.RLock()
+	defer ls.RUnlock()
+	if kind == lksdk.TrackKindAudio {
+		return ls.audio
+	}
+	return ls.video
+}
+
+// Sender wallclock of an RTP timestamp, false if no sender report was received yet
+func (ls *ov3LipSync) ntpTime(kind lksdk.TrackKind, rtpTime uint32) (int64, bool) {
+	mapping := ls.mapping(kind)
+	if !mapping.valid {
+		return 0, false
+	}
+	// Signed difference so timestamps before the report and wraparounds are handled
+	diff := int64(int32(rtpTime - mapping.rtpTime))
+	return mapping.ntpTime + diff*1000000000/int64(mapping.clockRate), true
+}
+
+func (ls *ov3LipSync) reset(kind lksdk.TrackKind) {
+	ls.Lock()
+	defer ls.Unlock()
+	if kind == lksdk.TrackKindAudio {
+		ls.audio = srMapping{}
+	} else if kind == lksdk.TrackKindVideo {
+		ls.video = srMapping{}
+	}
+}
+
+// Returns the position on the timeline of a buffer with the given sender wallclock, negative if it would
+// be before its start. The first buffer sets the start
+func (base *syncBase) ptsFor(ntpTime int64, pts uint64) int64 {
+	base.Lock()
+	defer base.Unlock()
+
+	if !base.set {
+		base.set = true
+		base.ntpTime = ntpTime
+		base.pts = pts
+	}
+	return int64(base.pts) + ntpTime - base.ntpTime
+}
+
+func absDiff(a uint64, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// Size of the RTP header without CSRCs nor extensions
+const rtpFixedHeaderSize = 12
+
+// Timestamp of an RTP buffer, only the fixed header is copied out of it
+func rtpBufferTimestamp(buffer *gst.Buffer) (uint32, bool) {
+	if buffer.GetSize() < rtpFixedHeaderSize {
+		return 0, false
+	}
+	header := buffer.Extract(0, rtpFixedHeaderSize)
+	if header[0]>>6 != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(header[4:8]), true
+}
+
+var ntpReferenceCaps *gst.Caps
+var ntpReferenceOnce sync.Once
+
+// Reference for GstReferenceTimestampMeta, nanoseconds since the NTP epoch
+func ntpReference() *gst.Caps {
+	ntpReferenceOnce.Do(func() {
+		ntpReferenceCaps = gst.NewCapsFromString("timestamp/x-ntp")
+	})
+	return ntpReferenceCaps
+}
+
+// Attaches the sender wallclock to an RTP buffer leaving the jitterbuffer as a reference timestamp meta, which
+// depayloaders keep on their output. If lip sync is enabled, also sets the PTS from that wallclock, so audio and
+// video of the subscriber (or all the subscribers of an aligned room) share the same timebase.
+// LiveKit generates the sender reports of forwarded tracks from its own clock, so wallclocks of different
+// participants can be compared.
+func (lk *ov3Subscriber) DoSynchronize(w *AppWriter, pad *gst.Pad, buffer *gst.Buffer) {
+	if buffer.PresentationTimestamp() == gst.ClockTimeNone {
+		return
+	}
+	bufferPts := uint64(buffer.PresentationTimestamp())
+	if !buffer.IsWritable() {
+		root.logger.Debugw(fmt.Sprintf("DoSynchronize: buffer not writable on track %s", w.pub.SID()))
+		return
+	}
+
+	rtpTime, ok := rtpBufferTimestamp(buffer)
+	if !ok {
+		return
+	}
+	ntpTime, ok := w.subscription.lipSync.ntpTime(w.kind, rtpTime)
+	if !ok {
+		return
+	}
+	buffer.AddReferenceTimestampMeta(ntpReference(), gst.ClockTime(uint64(ntpTime)), gst.ClockTimeNone)
+
+	if !lk.options.LipSync {
+		return
+	}
+	pts, runningOffset, ok := lk.timelinePts(ntpTime, bufferPts)
+	if !ok {
+		root.logger.Debugw(fmt.Sprintf("DoSynchronize: %s timeline of track %s out of sync, rebasing", w.kind, w.pub.SID()))
+		return
+	}
+	if pad.GetOffset() != runningOffset {
+		pad.SetOffset(runningOffset)
+	}
+	buffer.SetPresentationTimestamp(gst.ClockTime(pts))
+}
+
+// Position of a buffer on the timeline of the subscriber and the offset of its running time over that position.
+// A subscriber joining a timeline late, or moved to the room one, keeps the timeline PTS and gets its running
+// time offset once, so it stays in time with its own pipeline. After that a subscriber out of sync only corrects
+// its own position, the timeline may be shared with the rest of the room. False while rebasing.
+func (lk *ov3Subscriber) timelinePts(ntpTime int64, bufferPts uint64) (int64, int64, bool) {
+	lk.timelineLock.Lock()
+	defer lk.timelineLock.Unlock()
+
+	pts := lk.timeline.ptsFor(ntpTime, bufferPts) + lk.timelineOffset
+	if pts < 0 {
+		lk.timelineOffset -= pts
+		return 0, 0, false
+	}
+	if !lk.timelineJoined {
+		lk.timelineJoined = true
+		lk.runningOffset = int64(bufferPts) - pts
+	}
+	running := pts + lk.runningOffset
+	if (running < 0) || (absDiff(uint64(running), bufferPts) > maxSyncCorrection) {
+		lk.timelineOffset += int64(bufferPts) - running
+		return 0, 0, false
+	}
+	return pts, lk.runningOffset, true
+}
+
+// Moves the subscriber to another timeline, its running time offset is computed again on the next buffer
+func (lk *ov3Subscriber) setTimeline(timeline *syncBase) {
+	lk.timelineLock.Lock()
+	defer lk.timelineLock.Unlock()
+
+	lk.timeline = timeline
+	lk.timelineOffset = 0
+	lk.timelineJoined = false
+}
+
+// Keeps the RTP stream pushed to the subscriber bins continuous when the track of a kind is replaced
+// (participant toggling its camera gets a new track SID). Packets of the new track keep the SSRC of the
+// first one and have sequence numbers and timestamps continuing from the last packet pushed, so
+// jitterbuffers and depayloaders of the subscribers see a single stream.
+type rtpContinuity struct {
+	sync.Mutex
+	writer   *AppWriter
+	ssrc     uint32
+	format   trackFormat
+	snOffset uint16
+	tsOffset uint32
+
+	// Highest packet pushed so far
+	pushed   bool
+	lastSN   uint16
+	lastTS   uint32
+	lastTime time.Time
+}
+
+func (c *rtpContinuity) started() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.pushed
+}
+
+// Rewrites a packet of the writer before pushing it
+func (c *rtpContinuity) munge(w *AppWriter, pkt *rtp.Packet) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.writer != w {
+		if c.pushed && (c.format == writerFormat(w)) {
+			// Timestamps advance as if the stream had not stopped meanwhile
+			elapsed := uint32(time.Since(c.lastTime).Seconds() * float64(w.ClockRate))
+			c.snOffset = c.lastSN + 1 - pkt.SequenceNumber
+			c.tsOffset = c.lastTS + elapsed - pkt.Timestamp
+		} else {
+			// A different codec or payload type rebuilds the subscriber chain, so the stream starts over
+			c.ssrc = pkt.SSRC
+			c.snOffset = 0
+			c.tsOffset = 0
+		}
+		c.writer = w
+		c.format = writerFormat(w)
+		root.logger.Debugw(fmt.Sprintf("munge: %s stream continues with track %s, offsets sn %d ts %d", w.kind, w.pub.SID(), c.snOffset, c.tsOffset))
+	}
+
+	pkt.SSRC = c.ssrc
+	pkt.SequenceNumber += c.snOffset
+	pkt.Timestamp += c.tsOffset
+
+	if !c.pushed || (int16(pkt.SequenceNumber-c.lastSN) > 0) {
+		c.pushed = true
+		c.lastSN = pkt.SequenceNumber
+		c.lastTS = pkt.Timestamp
+		c.lastTime = time.Now()
+	}
+}
+
+// Sender reports of the writer on the pushed stream, false if no packet of the writer has been pushed
+// yet and so the mapping is still unknown
+func (c *rtpContinuity) mungeSR(w *AppWriter, sr *rtcp.SenderReport) (*rtcp.SenderReport, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.writer != w {
+		return nil, false
+	}
+	munged := *sr
+	munged.SSRC = c.ssrc
+	munged.RTPTime += c.tsOffset
+	return &munged, true
+}
+
+// Sequence number of the original track for one of the pushed stream (retransmission requests)
+func (c *rtpContinuity) originalSN(w *AppWriter, sn uint16) uint16 {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.writer != w {
+		return sn
+	}
+	return sn - c.snOffset
+}
@@ -30,6 +30,7 @@ import (
 	"fmt"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -51,6 +52,7 @@ type ov3TrackPublisher struct {
 	kind        lksdk.TrackKind
 	track       *lksdk.LocalSampleTrack
 	sid         string
+	publication *lksdk.LocalTrackPublication
 	opts        *lksdk.TrackPublicationOptions
 	bin         *gst.Bin
 	sinkTee     *gst.Element
@@ -61,6 +63,21 @@ type ov3TrackPublisher struct {
 
 	endStream core.Fuse
 
+	writeErrors atomic.Uint64
+
+	// Samples are dropped while muted, either locally or by a moderator from the server
+	muted atomic.Bool
+	// Last mute requested by the server, a stall resuming must not unmute over it
+	serverMuted atomic.Bool
+	// Mute state we last signalled to the room, mute callbacks not matching it come from the server
+	roomMuted atomic.Bool
+	// No samples from the input for the configured time, the track is shown muted meanwhile
+	stalled atomic.Bool
+	// The input was paused on purpose, a paused file for instance, so no samples is no stall
+	idle atomic.Bool
+	// Last bitrate requested to the encoder from congestion feedback, in bps
+	targetBitrate atomic.Uint64
+
 	inputSignalHandler   glib.SignalHandle
 	sinkPadSignalHandler glib.SignalHandle
 }
@@ -87,7 +104,7 @@ func (tr *ov3TrackPublisher) HandlePLI() error {
 	}
 }
 
-func (tr *ov3TrackPublisher) createPublishTrack(mimeType string) (*lksdk.LocalSampleTrack, error) {
+func (tr *ov3TrackPublisher) createPublishTrack(codec webrtc.RTPCodecCapability) (*lksdk.LocalSampleTrack, error) {
 	onRTCP := func(pkt rtcp.Packet) {
 		switch pkt.(type) {
 		case *rtcp.PictureLossIndication:
@@ -97,12 +114,14 @@ func (tr *ov3TrackPublisher) createPublishTrack(mimeType string) (*lksdk.LocalSa
 			} else {
 				root.logger.Debugw(fmt.Sprintf("PLI correctly sent for publisher %s %s", tr.publisher.id, &tr.kind))
 			}
+		case *rtcp.ReceiverEstimatedMaximumBitrate:
+			if tr.kind == lksdk.TrackKindVideo {
+				tr.setTargetBitrate(uint64(pkt.(*rtcp.ReceiverEstimatedMaximumBitrate).Bitrate), "remb")
+			}
 		}
 	}
 
-	track, err := lksdk.NewLocalSampleTrack(webrtc.RTPCodecCapability{
-		MimeType: mimeType,
-	},
+	track, err := lksdk.NewLocalSampleTrack(codec,
 		lksdk.WithRTCPHandler(onRTCP))
 
 	if err != nil {
@@ -195,6 +214,18 @@ func (trPub *ov3TrackPublisher) completeAudioOpusPipeline() error {
 	return trPub.addParserandSink(opusParser, "rtpopuspay", "audio/x-opus")
 }
 
+// G.711 is sent as is, in 20 ms packets as SIP endpoints expect
+func (trPub *ov3TrackPublisher) completeAudioG711Pipeline(payloaderStr string, parsedCaps string) error {
+	if err := trPub.addParserandSink(nil, payloaderStr, parsedCaps); err != nil {
+		return err
+	}
+	payloader := trPub.sinkPad.GetParentElement()
+	payloader.SetProperty("min-ptime", int64(20*time.Millisecond))
+	payloader.SetProperty("max-ptime", int64(20*time.Millisecond))
+
+	return nil
+}
+
 func (trPub *ov3TrackPublisher) completeVideoH264Pipeline() error {
 	var h264Parser *gst.Element
 	var err error
@@ -228,6 +259,10 @@ func (trPub *ov3TrackPublisher) completePublisherPipeline(codec string) error {
 	switch codec {
 	case "audio/x-opus":
 		return trPub.completeAudioOpusPipeline()
+	case "audio/x-mulaw":
+		return trPub.completeAudioG711Pipeline("rtppcmupay", "audio/x-mulaw,rate=8000,channels=1")
+	case "audio/x-alaw":
+		return trPub.completeAudioG711Pipeline("rtppcmapay", "audio/x-alaw,rate=8000,channels=1")
 	case "video/x-h264":
 		return trPub.completeVideoH264Pipeline()
 	case "video/x-vp8":
@@ -268,7 +303,7 @@ func (trPub *ov3TrackPublisher) createSinkElementsForPublisher(kind lksdk.TrackK
 		return errors.New("createSinkElementsForPublisher: Cannot create capsfilter element for publisher")
 	}
 	if kind == lksdk.TrackKindAudio {
-		caps = gst.NewCapsFromString("audio/x-opus")
+		caps = gst.NewCapsFromString("audio/x-opus;audio/x-mulaw,rate=8000,channels=1;audio/x-alaw,rate=8000,channels=1")
 	} else if kind == lksdk.TrackKindVideo {
 		// FIXME: before setting this caps, the real ones supported by remote browsers in SDP negpotiation should be examined to be in sync
 		// as this caps negotiation is not in sync with browser SDP negotiation.
@@ -338,12 +373,23 @@ func (tr *ov3TrackPublisher) ReadSamples() {
 	tr.HandlePLI()
 
 	packet = &rtp.Packet{}
+	lastSample := time.Now()
 	for {
 		tr.Lock()
 		element := tr.element
 		if element != nil {
 			tr.Unlock()
 			sample = element.TryPullSample(gst.ClockTime(500 * time.Millisecond))
+			if sample != nil {
+				lastSample = time.Now()
+				if tr.stalled.Load() {
+					tr.setStalled(false, 0)
+				}
+			} else if tr.idle.Load() {
+				lastSample = time.Now()
+			} else if stall := getConfig().publisherStall(); (stall > 0) && !tr.stalled.Load() && (time.Since(lastSample) > stall) {
+				tr.setStalled(true, time.Since(lastSample))
+			}
 		} else {
 			if tr.endStream.IsBroken() {
 				tr.Unlock()
@@ -362,12 +408,14 @@ func (tr *ov3TrackPublisher) ReadSamples() {
 			root.logger.Infow(fmt.Sprintf("ReadSamples: Ending reader task for publisher %s %s", tr.publisher.id, &tr.kind))
 			return
 		}
-		if sample != nil {
+		if (sample != nil) && !tr.muted.Load() {
 			buffer := sample.GetBuffer()
 			if buffer != nil {
 				packet.Unmarshal(buffer.Bytes())
 				err := localTrack.WriteRTP(packet, nil)
 				if err != nil {
+					tr.writeErrors.Add(1)
+					metrics.publishWriteErrors.Add(1)
 					root.logger.Warnw(fmt.Sprintf("ReadSamples: could not write sample to local track %s %s", tr.publisher.id, &tr.kind), err)
 				}
 			}
@@ -433,7 +481,7 @@ func getIntFieldFromGstStructure(str *gst.Structure, fieldName string) (int64, e
 func (tr *ov3TrackPublisher) PublishLocalTrack(ingressId string, screenShare bool, caps *gst.Caps) {
 	var localTrack *lksdk.LocalSampleTrack
 	var err error
-	var webrtcCodec string
+	var webrtcCodec webrtc.RTPCodecCapability
 	var ltp *lksdk.LocalTrackPublication
 
 	structure := caps.GetStructureAt(0)
@@ -447,16 +495,23 @@ func (tr *ov3TrackPublisher) PublishLocalTrack(ingressId string, screenShare boo
 
 	switch tr.codec {
 	case "video/x-vp8":
-		webrtcCodec = webrtc.MimeTypeVP8
+		webrtcCodec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}
 
 	case "video/x-h264":
-		webrtcCodec = webrtc.MimeTypeH264
+		webrtcCodec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}
 
 	case "video/x-vp9":
-		webrtcCodec = webrtc.MimeTypeVP9
+		webrtcCodec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9}
 
 	case "audio/x-opus":
-		webrtcCodec = webrtc.MimeTypeOpus
+		webrtcCodec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}
+
+	// Only published if G.711 is enabled in the SFU, otherwise PublishTrack fails
+	case "audio/x-mulaw":
+		webrtcCodec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000, Channels: 1}
+
+	case "audio/x-alaw":
+		webrtcCodec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000, Channels: 1}
 
 	default:
 		root.logger.Warnw(fmt.Sprintf("PublishLocalTrack: Codec %s not supported in publisher %s, %s", tr.codec, tr.publisher.id, &tr.kind), nil)
@@ -499,6 +554,12 @@ func (tr *ov3TrackPublisher) PublishLocalTrack(ingressId string, screenShare boo
 			return
 		}
 		tr.sid = ltp.SID()
+		tr.publication = ltp
+		// Muted before the track could be published
+		tr.roomMuted.Store(tr.muted.Load())
+		if tr.muted.Load() {
+			ltp.SetMuted(true)
+		}
 
 		go tr.ReadSamples()
 	}
@@ -511,6 +572,7 @@ func (tr *ov3TrackPublisher) UnpublishLocalTrack() {
 		return
 	}
 	tr.track = nil
+	tr.publication = nil
 	tr.endStream.Break()
 	tr.Unlock()
 
@@ -520,6 +582,85 @@ func (tr *ov3TrackPublisher) UnpublishLocalTrack() {
 	}
 }
 
+// Mutes the published track, the mute is signalled to LiveKit so clients see the muted state
+func (tr *ov3TrackPublisher) setMuted(muted bool) {
+	tr.Lock()
+	publication := tr.publication
+	tr.Unlock()
+
+	// An explicit mute or unmute replaces the one of the server
+	tr.serverMuted.Store(false)
+	if publication != nil {
+		tr.roomMuted.Store(muted)
+		publication.SetMuted(muted)
+	}
+	tr.applyMuted(muted)
+}
+
+// Mute changes of the publication we did not signal ourselves come from the server (moderators). One
+// arriving during a stall is remembered so that resuming keeps it
+func (tr *ov3TrackPublisher) remoteMuted(muted bool) {
+	if tr.roomMuted.Swap(muted) == muted {
+		return
+	}
+	root.logger.Debugw(fmt.Sprintf("remoteMuted: %s track of publisher %s muted %t by the server", tr.kind, tr.publisher.id, muted))
+
+	tr.serverMuted.Store(muted)
+	tr.applyMuted(muted)
+}
+
+// Stops or resumes pushing samples, also called when the mute comes from the server
+func (tr *ov3TrackPublisher) applyMuted(muted bool) {
+	if tr.muted.Swap(muted) == muted {
+		return
+	}
+	root.logger.Debugw(fmt.Sprintf("applyMuted: %s track of publisher %s muted %t", tr.kind, tr.publisher.id, muted))
+
+	// Samples dropped while muted leave subscribers without reference frames
+	if !muted && (tr.kind == lksdk.TrackKindVideo) {
+		if err := tr.HandlePLI(); err != nil {
+			root.logger.Warnw(fmt.Sprintf("applyMuted: could not force key frame for publisher %s", tr.publisher.id), err)
+		}
+	}
+}
+
+// The filter feeding the publisher stopped producing, the room would show a live but frozen participant,
+// so the track is muted until samples arrive again. Explicit and server mutes are kept when resuming
+func (tr *ov3TrackPublisher) setStalled(stalled bool, since time.Duration) {
+	tr.Lock()
+	publication := tr.publication
+	tr.Unlock()
+
+	tr.stalled.Store(stalled)
+	data := map[string]interface{}{
+		"ingressId": tr.publisher.ingress.ingressId,
+		"kind":      string(tr.kind),
+		"sid":       tr.sid,
+	}
+	if stalled {
+		root.logger.Warnw(fmt.Sprintf("setStalled: no samples for %s on %s track of publisher %s, muting", since, tr.kind, tr.publisher.id), nil)
+		if publication != nil {
+			tr.roomMuted.Store(true)
+			publication.SetMuted(true)
+		}
+		data["elapsed"] = since.Milliseconds()
+		emitEvent(tr.publisher.id, "PublisherStalled", data)
+		return
+	}
+
+	root.logger.Infow(fmt.Sprintf("setStalled: samples resumed on %s track of publisher %s", tr.kind, tr.publisher.id))
+	if (publication != nil) && !tr.muted.Load() && !tr.serverMuted.Load() {
+		tr.roomMuted.Store(false)
+		publication.SetMuted(false)
+	}
+	if tr.kind == lksdk.TrackKindVideo {
+		if err := tr.HandlePLI(); err != nil {
+			root.logger.Warnw(fmt.Sprintf("setStalled: could not force key frame for publisher %s", tr.publisher.id), err)
+		}
+	}
+	emitEvent(tr.publisher.id, "PublisherResumed", data)
+}
+
 func (tr *ov3TrackPublisher) checkCapsAreReadyForPublish(caps *gst.Caps) bool {
 	var currentStructure *gst.Structure
 
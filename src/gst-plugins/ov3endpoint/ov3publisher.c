@@ -69,6 +69,11 @@ struct _Ov3PublisherPrivate {
   gchar *participant_id;
   gchar *ingressId;
   gchar *publisherId;
+  gchar *ingestId;
+  gchar *ingestSource;
+  gchar *ingestOptions;
+  gboolean ingestFile;
+  gboolean ingestLoop;
   gboolean screenshare;
   gboolean publishAudio;
   gboolean publishVideo;
@@ -91,6 +96,10 @@ enum
   PROP_OV3_IS_SCREENSHARE,
   PROP_OV3_PUBLISH_AUDIO,
   PROP_OV3_PUBLISH_VIDEO,
+  PROP_OV3_INGEST_SOURCE,
+  PROP_OV3_INGEST_OPTIONS,
+  PROP_OV3_INGEST_FILE,
+  PROP_OV3_INGEST_LOOP,
   PROP_OV3_CONNECTED,
 };
 
@@ -101,12 +110,81 @@ enum
   /* signals */
   SIGNAL_CONNECT,
   SIGNAL_DISCONNECT,
+  SIGNAL_SET_MUTED,
+  SIGNAL_PAUSE_FILE,
+  SIGNAL_SEEK_FILE,
+  SIGNAL_DUMP_STATE,
+  SIGNAL_SET_CONFIG,
+  SIGNAL_EVENT,
 
   LAST_SIGNAL
 };
 
 static guint obj_signals[LAST_SIGNAL] = { 0 };
 
+/* Publishers and ingests by id, events from libov3endpoint are raised on the element owning the source */
+static GMutex publishers_lock;
+static GHashTable *publishers = NULL;
+
+
+static void
+publisher_ref_free (gpointer data)
+{
+  GWeakRef *ref = (GWeakRef *) data;
+
+  g_weak_ref_clear (ref);
+  g_free (ref);
+}
+
+static void
+ov3_publisher_track (Ov3Publisher *self, const gchar *id)
+{
+  GWeakRef *ref = g_new0 (GWeakRef, 1);
+
+  g_weak_ref_init (ref, self);
+  g_mutex_lock (&publishers_lock);
+  g_hash_table_insert (publishers, g_strdup (id), ref);
+  g_mutex_unlock (&publishers_lock);
+}
+
+static void
+ov3_publisher_untrack (const gchar *id)
+{
+  g_mutex_lock (&publishers_lock);
+  g_hash_table_remove (publishers, id);
+  g_mutex_unlock (&publishers_lock);
+}
+
+// Called from a single libov3endpoint thread, never with any of its locks held
+static void
+ov3_publisher_event_callback (char *source, char *name, char *data)
+{
+  GWeakRef *ref;
+  Ov3Publisher *self = NULL;
+
+  g_mutex_lock (&publishers_lock);
+  ref = g_hash_table_lookup (publishers, source);
+  if (ref != NULL) {
+    self = g_weak_ref_get (ref);
+  }
+  g_mutex_unlock (&publishers_lock);
+
+  if (self == NULL) {
+    GST_DEBUG ("Dropping event %s from %s, no publisher owns it", name, source);
+    return;
+  }
+
+  g_signal_emit (self, obj_signals[SIGNAL_EVENT], 0, name, data);
+  g_object_unref (self);
+}
+
+// Results from libov3endpoint starting with ERROR tell why the call failed
+static gboolean
+ov3_publisher_result_ok (gchar *result)
+{
+  return (result != NULL) && (strlen(result) > 0) && (strncmp(result, "ERROR", 5) != 0);
+}
+
 
 
 static void
@@ -138,6 +216,42 @@ video_sink_pad_added (GstElement * element,
 
 
 
+// Ingests publish a stream or a local file on the connection instead of the media reaching this element
+static void
+ov3_publisher_connect_ingest (Ov3Publisher *self)
+{
+  gchar *result;
+
+  result = connectToRoom (self->priv->url, self->priv->key, self->priv->secret, self->priv->room, self->priv->participant_name, self->priv->participant_id);
+  // If result starts with ERROR, then no connection could be made
+  if (!ov3_publisher_result_ok (result)) {
+    GST_ERROR_OBJECT(self, "Could not connect %s to room %s on service %s for publishing", self->priv->participant_name, self->priv->room, self->priv->url);
+    return;
+  }
+
+  self->priv->ingressId = result;
+  if (self->priv->ingestFile) {
+    result = publishFile (self->priv->ingressId, self->priv->ingestSource, self->priv->ingestLoop, self->priv->screenshare);
+  } else {
+    result = startStreamIngest (self->priv->ingressId, self->priv->ingestSource, self->priv->screenshare, self->priv->ingestOptions);
+  }
+  // The source is never logged, it may carry stream keys
+  if (!ov3_publisher_result_ok (result)) {
+    GST_ERROR_OBJECT(self, "Could not ingest for %s to room %s on service %s: %s", self->priv->participant_name, self->priv->room, self->priv->url, result);
+    g_free (result);
+    g_free (disconnectFromRoom (self->priv->ingressId));
+    g_free (self->priv->ingressId);
+    self->priv->ingressId = NULL;
+    return;
+  }
+
+  self->priv->ingestId = result;
+  ov3_publisher_track (self, self->priv->ingestId);
+
+  self->priv->connected = TRUE;
+  GST_INFO_OBJECT(self, "Connected and ingesting %s to room %s on service %s", self->priv->participant_name, self->priv->room, self->priv->url);
+}
+
 static void
 ov3_publisher_connect (Ov3Publisher *self)
 {
@@ -145,8 +259,10 @@ ov3_publisher_connect (Ov3Publisher *self)
   GstBin *audio_sink = NULL;
   GstBin *video_sink = NULL;
 
-
-
+  if ((self->priv->ingestSource != NULL) && (strlen(self->priv->ingestSource) > 0)) {
+    ov3_publisher_connect_ingest (self);
+    return;
+  }
 
   if (self->priv->publishAudio) {
     GstElement *bin;
@@ -186,17 +302,139 @@ ov3_publisher_connect (Ov3Publisher *self)
   }
 
   self->priv->publisherId = result;
+  ov3_publisher_track (self, self->priv->publisherId);
 
   self->priv->connected = TRUE;
   GST_INFO_OBJECT(self, "Connected and publishing %s to room %s on service %s for publishing", self->priv->participant_name, self->priv->room, self->priv->url);
 }
 
+static gboolean
+ov3_publisher_set_muted (Ov3Publisher *self, const gchar *kind, gboolean muted)
+{
+  gchar *result;
+  gboolean ok;
+
+  if (self->priv->publisherId == NULL) {
+    GST_ERROR_OBJECT(self, "Muting needs a connected publisher of the element media");
+    return FALSE;
+  }
+
+  result = setPublisherMuted (self->priv->publisherId, (gchar *) kind, muted);
+  ok = ov3_publisher_result_ok (result);
+  if (!ok) {
+    GST_ERROR_OBJECT(self, "Could not mute %s of %s: %s", kind, self->priv->participant_name, result);
+  }
+  g_free (result);
+
+  return ok;
+}
+
+static gboolean
+ov3_publisher_pause_file (Ov3Publisher *self, gboolean paused)
+{
+  gchar *result;
+  gboolean ok;
+
+  if ((self->priv->ingestId == NULL) || !self->priv->ingestFile) {
+    GST_ERROR_OBJECT(self, "Pausing needs a published file");
+    return FALSE;
+  }
+
+  result = pauseFile (self->priv->ingestId, paused);
+  ok = ov3_publisher_result_ok (result);
+  if (!ok) {
+    GST_ERROR_OBJECT(self, "Could not pause file of %s: %s", self->priv->participant_name, result);
+  }
+  g_free (result);
+
+  return ok;
+}
+
+// Position in milliseconds from the start of the file
+static gboolean
+ov3_publisher_seek_file (Ov3Publisher *self, gint64 position)
+{
+  gchar *result;
+  gboolean ok;
+
+  if ((self->priv->ingestId == NULL) || !self->priv->ingestFile) {
+    GST_ERROR_OBJECT(self, "Seeking needs a published file");
+    return FALSE;
+  }
+
+  result = seekFile (self->priv->ingestId, position);
+  ok = ov3_publisher_result_ok (result);
+  if (!ok) {
+    GST_ERROR_OBJECT(self, "Could not seek file of %s: %s", self->priv->participant_name, result);
+  }
+  g_free (result);
+
+  return ok;
+}
+
+static gchar *
+ov3_publisher_dump_state (Ov3Publisher *self)
+{
+  gchar *result;
+
+  result = dumpState ();
+  if (!ov3_publisher_result_ok (result)) {
+    GST_ERROR_OBJECT(self, "Could not dump state: %s", result);
+    g_free (result);
+    return NULL;
+  }
+
+  return result;
+}
+
+static gchar *
+ov3_publisher_set_config (Ov3Publisher *self, const gchar *config)
+{
+  gchar *result;
+
+  result = setConfig ((gchar *) config);
+  if (!ov3_publisher_result_ok (result)) {
+    GST_ERROR_OBJECT(self, "Could not set configuration: %s", result);
+    g_free (result);
+    return NULL;
+  }
+
+  return result;
+}
+
 static void
 ov3_publisher_disconnect (Ov3Publisher *self)
 {
   gchar *result;
 
+  if (self->priv->ingestId != NULL) {
+    ov3_publisher_untrack (self->priv->ingestId);
+    if (self->priv->ingestFile) {
+      result = unpublishFile (self->priv->ingestId);
+    } else {
+      result = stopStreamIngest (self->priv->ingestId);
+    }
+    if (!ov3_publisher_result_ok (result)) {
+      GST_ERROR_OBJECT(self, "Could not stop ingest of %s in room %s on service %s", self->priv->participant_name, self->priv->room, self->priv->url);
+    }
+    g_free (result);
+    g_free (self->priv->ingestId);
+    self->priv->ingestId = NULL;
+    self->priv->connected = FALSE;
+
+    result = disconnectFromRoom(self->priv->ingressId);
+    g_free (self->priv->ingressId);
+    self->priv->ingressId = NULL;
+    if (!ov3_publisher_result_ok (result)) {
+      GST_INFO_OBJECT(self, "Not disconnecting %s from room %s on service %s", self->priv->participant_name, self->priv->room, self->priv->url);
+      return;
+    }
+    GST_INFO_OBJECT(self, "Disconnected ingest %s from room %s on service %s", self->priv->participant_name, self->priv->room, self->priv->url);
+    return;
+  }
+
   if (self->priv->publisherId != NULL) {
+    ov3_publisher_untrack (self->priv->publisherId);
     result = unpublishParticipant(self->priv->screenshare, self->priv->publisherId);
     // If results begins with ERROR then no unsunscription could be made
     if ((result == NULL) ||(strlen(result) == 0) || (strncmp(result, "ERROR", 5) == 0)) {
@@ -253,8 +491,19 @@ ov3_publisher_finalize (GObject *object)
     g_free(self->priv->ingressId);
   }
   if (self->priv->publisherId != NULL) {
+    ov3_publisher_untrack (self->priv->publisherId);
     g_free(self->priv->publisherId);
   }
+  if (self->priv->ingestId != NULL) {
+    ov3_publisher_untrack (self->priv->ingestId);
+    g_free(self->priv->ingestId);
+  }
+  if (self->priv->ingestSource != NULL) {
+    g_free(self->priv->ingestSource);
+  }
+  if (self->priv->ingestOptions != NULL) {
+    g_free(self->priv->ingestOptions);
+  }
 }
 
 
@@ -307,6 +556,24 @@ ov3_publisher_set_property (GObject * object, guint property_id,
       self->priv->publishVideo = g_value_get_boolean (value);
       break;
     }
+    case PROP_OV3_INGEST_SOURCE:{
+      g_free (self->priv->ingestSource);
+      self->priv->ingestSource = g_value_dup_string (value);
+      break;
+    }
+    case PROP_OV3_INGEST_OPTIONS:{
+      g_free (self->priv->ingestOptions);
+      self->priv->ingestOptions = g_value_dup_string (value);
+      break;
+    }
+    case PROP_OV3_INGEST_FILE:{
+      self->priv->ingestFile = g_value_get_boolean (value);
+      break;
+    }
+    case PROP_OV3_INGEST_LOOP:{
+      self->priv->ingestLoop = g_value_get_boolean (value);
+      break;
+    }
     default:
       G_OBJECT_WARN_INVALID_PROPERTY_ID (object, property_id, pspec);
       break;
@@ -356,6 +623,18 @@ ov3_publisher_get_property (GObject * object, guint property_id,
       g_value_set_boolean (value, self->priv->publishVideo);
       break;
     }
+    case PROP_OV3_INGEST_OPTIONS:{
+      g_value_set_string (value, self->priv->ingestOptions);
+      break;
+    }
+    case PROP_OV3_INGEST_FILE:{
+      g_value_set_boolean (value, self->priv->ingestFile);
+      break;
+    }
+    case PROP_OV3_INGEST_LOOP:{
+      g_value_set_boolean (value, self->priv->ingestLoop);
+      break;
+    }
     case PROP_OV3_CONNECTED: {
       g_value_set_boolean (value, self->priv->connected);
       break;
@@ -379,6 +658,11 @@ ov3_publisher_class_init (Ov3PublisherClass * klass)
 
   klass->ov3_connect = ov3_publisher_connect ;
   klass->ov3_disconnect = ov3_publisher_disconnect;
+  klass->ov3_set_muted = ov3_publisher_set_muted;
+  klass->ov3_pause_file = ov3_publisher_pause_file;
+  klass->ov3_seek_file = ov3_publisher_seek_file;
+  klass->ov3_dump_state = ov3_publisher_dump_state;
+  klass->ov3_set_config = ov3_publisher_set_config;
 
   gst_element_class_set_static_metadata (GST_ELEMENT_CLASS (klass),
       "Ov3Publisher", "Generic/KmsElement", "Kurento OpenVidu3 WebRtc publisher",
@@ -429,6 +713,26 @@ ov3_publisher_class_init (Ov3PublisherClass * klass)
           "OpenVidu3 ScreenShare", "TRUE if this endpoint must publish a Video track",
           FALSE,
 		  G_PARAM_READWRITE | G_PARAM_STATIC_STRINGS));      
+  g_object_class_install_property (gobject_class, PROP_OV3_INGEST_SOURCE,
+      g_param_spec_string ("ov3-ingest-source",
+          "OpenVidu3 ingest source", "RTMP, SRT or RTSP URL, or local file path, published instead of the media reaching this endpoint",
+          "",
+		  G_PARAM_WRITABLE | G_PARAM_STATIC_STRINGS));      
+  g_object_class_install_property (gobject_class, PROP_OV3_INGEST_OPTIONS,
+      g_param_spec_string ("ov3-ingest-options",
+          "OpenVidu3 ingest options", "JSON options for a stream ingest",
+          "",
+		  G_PARAM_READWRITE | G_PARAM_STATIC_STRINGS));      
+  g_object_class_install_property (gobject_class, PROP_OV3_INGEST_FILE,
+      g_param_spec_boolean ("ov3-ingest-file",
+          "OpenVidu3 ingest file", "TRUE if the ingest source is a local file",
+          FALSE,
+		  G_PARAM_READWRITE | G_PARAM_STATIC_STRINGS));      
+  g_object_class_install_property (gobject_class, PROP_OV3_INGEST_LOOP,
+      g_param_spec_boolean ("ov3-ingest-loop",
+          "OpenVidu3 ingest loop", "TRUE if a published file must restart when it ends",
+          FALSE,
+		  G_PARAM_READWRITE | G_PARAM_STATIC_STRINGS));      
   g_object_class_install_property (gobject_class, PROP_OV3_CONNECTED,
       g_param_spec_boolean ("ov3-connected",
           "OpenVidu3 Subscriber connected", "True if the endpoint is currently connected and subscribing tracks",
@@ -448,6 +752,42 @@ ov3_publisher_class_init (Ov3PublisherClass * klass)
       G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
       G_STRUCT_OFFSET (Ov3PublisherClass, ov3_disconnect), NULL, NULL,
       NULL, G_TYPE_NONE, 0, G_TYPE_NONE);
+  obj_signals[SIGNAL_SET_MUTED] =
+      g_signal_new ("ov3-set-muted",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3PublisherClass, ov3_set_muted), NULL, NULL,
+      NULL, G_TYPE_BOOLEAN, 2, G_TYPE_STRING, G_TYPE_BOOLEAN);
+  obj_signals[SIGNAL_PAUSE_FILE] =
+      g_signal_new ("ov3-pause-file",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3PublisherClass, ov3_pause_file), NULL, NULL,
+      NULL, G_TYPE_BOOLEAN, 1, G_TYPE_BOOLEAN);
+  obj_signals[SIGNAL_SEEK_FILE] =
+      g_signal_new ("ov3-seek-file",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3PublisherClass, ov3_seek_file), NULL, NULL,
+      NULL, G_TYPE_BOOLEAN, 1, G_TYPE_INT64);
+  obj_signals[SIGNAL_DUMP_STATE] =
+      g_signal_new ("ov3-dump-state",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3PublisherClass, ov3_dump_state), NULL, NULL,
+      NULL, G_TYPE_STRING, 0, G_TYPE_NONE);
+  obj_signals[SIGNAL_SET_CONFIG] =
+      g_signal_new ("ov3-set-config",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3PublisherClass, ov3_set_config), NULL, NULL,
+      NULL, G_TYPE_STRING, 1, G_TYPE_STRING);
+  obj_signals[SIGNAL_EVENT] =
+      g_signal_new ("ov3-event",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_RUN_LAST,
+      0, NULL, NULL,
+      NULL, G_TYPE_NONE, 2, G_TYPE_STRING, G_TYPE_STRING);
 
   g_type_class_add_private (klass, sizeof (Ov3PublisherPrivate));
 
@@ -468,6 +808,12 @@ ov3_publisher_init (Ov3Publisher * self)
   self->priv->participant_id = g_strdup ("");
   self->priv->screenshare = FALSE;
   self->priv->ingressId = NULL;
+  self->priv->publisherId = NULL;
+  self->priv->ingestId = NULL;
+  self->priv->ingestSource = g_strdup ("");
+  self->priv->ingestOptions = g_strdup ("");
+  self->priv->ingestFile = FALSE;
+  self->priv->ingestLoop = FALSE;
   self->priv->connected = FALSE;
   self->priv->audio_pad_added_conn = 0;
   self->priv->video_pad_added_conn = 0;
@@ -480,6 +826,14 @@ ov3_publisher_init (Ov3Publisher * self)
 gboolean
 kms_ov3_publisher_plugin_init (GstPlugin * plugin)
 {
+  gchar *result;
+
+  publishers = g_hash_table_new_full (g_str_hash, g_str_equal, g_free, publisher_ref_free);
+  result = setEventCallback ((void *) ov3_publisher_event_callback);
+  if (!ov3_publisher_result_ok (result)) {
+    GST_WARNING ("Could not register OpenVidu3 event callback: %s", result);
+  }
+  g_free (result);
 
   return gst_element_register (plugin, PLUGIN_NAME, GST_RANK_NONE,
       KMS_TYPE_OV3_PUBLISHER);
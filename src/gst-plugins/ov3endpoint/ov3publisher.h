@@ -65,6 +65,11 @@ struct _Ov3PublisherClass
   /* signals */
   void (*ov3_connect) (Ov3Publisher *obj);
   void (*ov3_disconnect) (Ov3Publisher *obj);
+  gboolean (*ov3_set_muted) (Ov3Publisher *obj, const gchar *kind, gboolean muted);
+  gboolean (*ov3_pause_file) (Ov3Publisher *obj, gboolean paused);
+  gboolean (*ov3_seek_file) (Ov3Publisher *obj, gint64 position);
+  gchar * (*ov3_dump_state) (Ov3Publisher *obj);
+  gchar * (*ov3_set_config) (Ov3Publisher *obj, const gchar *config);
 };
 
 GType ov3_publisher_get_type (void);
@@ -68,6 +68,14 @@ struct _Ov3SubscriberPrivate {
   gchar *participant;
   gchar *egressId;
   gchar *subscriberId;
+  gchar *options;
+  gboolean aligned;
+  gboolean mixed;
+  gchar *mixerOptions;
+  gchar *compositeOptions;
+  gchar *mixerId;
+  gchar *compositeId;
+  GSList *recordings;
   gboolean screenshare;
   gulong keyFrameProbeId;
   gboolean connected;
@@ -83,6 +91,11 @@ enum
   PROP_OV3_ROOM,
   PROP_OV3_PARTICIPANT_NAME,
   PROP_OV3_IS_SCREENSHARE,
+  PROP_OV3_OPTIONS,
+  PROP_OV3_ALIGNED,
+  PROP_OV3_MIXED,
+  PROP_OV3_MIXER_OPTIONS,
+  PROP_OV3_COMPOSITE_OPTIONS,
   PROP_OV3_CONNECTED,
 };
 
@@ -94,12 +107,66 @@ enum
   SIGNAL_CONNECT,
   SIGNAL_DISCONNECT,
   SIGNAL_REQUESTKF,
+  SIGNAL_SET_MIXER_GAIN,
+  SIGNAL_SET_COMPOSITE_LAYOUT,
+  SIGNAL_START_RECORDING,
+  SIGNAL_SPLIT_RECORDING,
+  SIGNAL_STOP_RECORDING,
+  SIGNAL_GET_RECORDING_STATUS,
+  SIGNAL_RELEASE_RECORDING,
+  SIGNAL_DUMP_STATE,
+  SIGNAL_SET_CONFIG,
 
   LAST_SIGNAL
 };
 
 static guint obj_signals[LAST_SIGNAL] = { 0 };
 
+// Results from libov3endpoint starting with ERROR tell why the call failed
+static gboolean
+ov3_subscriber_result_ok (gchar *result)
+{
+  return (result != NULL) && (strlen(result) > 0) && (strncmp(result, "ERROR", 5) != 0);
+}
+
+// Subscribers of an aligned room share a common timeline, existing ones are moved to it
+static void
+ov3_subscriber_align_room (Ov3Subscriber *self)
+{
+  gchar *result;
+
+  result = setRoomAlignment (self->priv->egressId, self->priv->aligned);
+  if (!ov3_subscriber_result_ok (result)) {
+    GST_WARNING_OBJECT(self, "Could not set alignment of room %s on service %s: %s", self->priv->room, self->priv->url, result);
+  }
+  g_free (result);
+}
+
+// Mixed subscribers receive the audio mix and the video composite of the whole room
+static gboolean
+ov3_subscriber_subscribe_room (Ov3Subscriber *self)
+{
+  gchar *result;
+
+  result = createAudioMixer (self->priv->egressId, self->priv->audio_src, self->priv->mixerOptions);
+  if (!ov3_subscriber_result_ok (result)) {
+    GST_ERROR_OBJECT(self, "Could not create audio mixer for room %s on service %s: %s", self->priv->room, self->priv->url, result);
+    g_free (result);
+    return FALSE;
+  }
+  self->priv->mixerId = result;
+
+  result = createVideoComposite (self->priv->egressId, self->priv->video_src, self->priv->compositeOptions);
+  if (!ov3_subscriber_result_ok (result)) {
+    GST_ERROR_OBJECT(self, "Could not create video composite for room %s on service %s: %s", self->priv->room, self->priv->url, result);
+    g_free (result);
+    return FALSE;
+  }
+  self->priv->compositeId = result;
+
+  return TRUE;
+}
+
 static void
 ov3_subscriber_connect (Ov3Subscriber *self)
 {
@@ -113,7 +180,24 @@ ov3_subscriber_connect (Ov3Subscriber *self)
   }
 
   self->priv->egressId = result;
-  result = subscribeParticipant (self->priv->participant, self->priv->screenshare, self->priv->egressId, self->priv->audio_src, self->priv->video_src);
+  if (self->priv->aligned) {
+    ov3_subscriber_align_room (self);
+  }
+
+  if (self->priv->mixed) {
+    if (!ov3_subscriber_subscribe_room (self)) {
+      return;
+    }
+    self->priv->connected = TRUE;
+    GST_INFO_OBJECT(self, "Connected and subscribing the mix of room %s on service %s", self->priv->room, self->priv->url);
+    return;
+  }
+
+  if ((self->priv->options != NULL) && (strlen(self->priv->options) > 0)) {
+    result = subscribeParticipantWithOptions (self->priv->participant, self->priv->screenshare, self->priv->egressId, self->priv->audio_src, self->priv->video_src, self->priv->options);
+  } else {
+    result = subscribeParticipant (self->priv->participant, self->priv->screenshare, self->priv->egressId, self->priv->audio_src, self->priv->video_src);
+  }
   // If result starts with ERROR, no subscription could be made
   if ((result == NULL) ||(strlen(result) == 0) || (strncmp(result, "ERROR", 5) == 0)) {
     GST_ERROR_OBJECT(self, "Could not subscribe %s to room %s on service %s", self->priv->participant, self->priv->room, self->priv->url);
@@ -132,10 +216,175 @@ ov3_subscriber_request_keyframe (Ov3Subscriber *self)
   requestKeyFrame (self->priv->subscriberId);
 }
 
+static gboolean
+ov3_subscriber_set_mixer_gain (Ov3Subscriber *self, const gchar *participant, gdouble gain)
+{
+  gchar *result;
+  gboolean ok;
+
+  if (self->priv->mixerId == NULL) {
+    GST_ERROR_OBJECT(self, "No audio mixer to set gain of %s", participant);
+    return FALSE;
+  }
+
+  result = setAudioMixerGain (self->priv->mixerId, (gchar *) participant, gain);
+  ok = ov3_subscriber_result_ok (result);
+  if (!ok) {
+    GST_ERROR_OBJECT(self, "Could not set gain of %s on mixer %s: %s", participant, self->priv->mixerId, result);
+  }
+  g_free (result);
+
+  return ok;
+}
+
+static gboolean
+ov3_subscriber_set_composite_layout (Ov3Subscriber *self, const gchar *layout)
+{
+  gchar *result;
+  gboolean ok;
+
+  if (self->priv->compositeId == NULL) {
+    GST_ERROR_OBJECT(self, "No video composite to set layout %s", layout);
+    return FALSE;
+  }
+
+  result = setCompositeLayout (self->priv->compositeId, (gchar *) layout);
+  ok = ov3_subscriber_result_ok (result);
+  if (!ok) {
+    GST_ERROR_OBJECT(self, "Could not set layout %s on composite %s: %s", layout, self->priv->compositeId, result);
+  }
+  g_free (result);
+
+  return ok;
+}
+
+// Records the participant subscribed by this endpoint, the recording is released on disconnect
+static gchar *
+ov3_subscriber_start_recording (Ov3Subscriber *self, const gchar *options)
+{
+  gchar *result;
+
+  if (!self->priv->connected || self->priv->mixed) {
+    GST_ERROR_OBJECT(self, "Recording needs a connected subscription to a participant");
+    return NULL;
+  }
+
+  result = startRecording (self->priv->egressId, self->priv->participant, self->priv->screenshare, (gchar *) options);
+  if (!ov3_subscriber_result_ok (result)) {
+    GST_ERROR_OBJECT(self, "Could not record %s in room %s on service %s: %s", self->priv->participant, self->priv->room, self->priv->url, result);
+    g_free (result);
+    return NULL;
+  }
+  self->priv->recordings = g_slist_prepend (self->priv->recordings, g_strdup (result));
+
+  return result;
+}
+
+static gboolean
+ov3_subscriber_split_recording (Ov3Subscriber *self, const gchar *recordingId)
+{
+  gchar *result;
+  gboolean ok;
+
+  result = splitRecording ((gchar *) recordingId);
+  ok = ov3_subscriber_result_ok (result);
+  if (!ok) {
+    GST_ERROR_OBJECT(self, "Could not split recording %s: %s", recordingId, result);
+  }
+  g_free (result);
+
+  return ok;
+}
+
+static gboolean
+ov3_subscriber_stop_recording (Ov3Subscriber *self, const gchar *recordingId)
+{
+  gchar *result;
+  gboolean ok;
+
+  result = stopRecording ((gchar *) recordingId);
+  ok = ov3_subscriber_result_ok (result);
+  if (!ok) {
+    GST_ERROR_OBJECT(self, "Could not stop recording %s: %s", recordingId, result);
+  }
+  g_free (result);
+
+  return ok;
+}
+
+static gchar *
+ov3_subscriber_get_recording_status (Ov3Subscriber *self, const gchar *recordingId)
+{
+  gchar *result;
+
+  result = getRecordingStatus ((gchar *) recordingId);
+  if (!ov3_subscriber_result_ok (result)) {
+    GST_ERROR_OBJECT(self, "Could not get status of recording %s: %s", recordingId, result);
+    g_free (result);
+    return NULL;
+  }
+
+  return result;
+}
+
+static gboolean
+ov3_subscriber_release_recording (Ov3Subscriber *self, const gchar *recordingId)
+{
+  GSList *item;
+  gchar *result;
+  gboolean ok;
+
+  item = g_slist_find_custom (self->priv->recordings, recordingId, (GCompareFunc) g_strcmp0);
+  if (item != NULL) {
+    g_free (item->data);
+    self->priv->recordings = g_slist_delete_link (self->priv->recordings, item);
+  }
+
+  result = releaseRecording ((gchar *) recordingId);
+  ok = ov3_subscriber_result_ok (result);
+  if (!ok) {
+    GST_ERROR_OBJECT(self, "Could not release recording %s: %s", recordingId, result);
+  }
+  g_free (result);
+
+  return ok;
+}
+
+static gchar *
+ov3_subscriber_dump_state (Ov3Subscriber *self)
+{
+  gchar *result;
+
+  result = dumpState ();
+  if (!ov3_subscriber_result_ok (result)) {
+    GST_ERROR_OBJECT(self, "Could not dump state: %s", result);
+    g_free (result);
+    return NULL;
+  }
+
+  return result;
+}
+
+static gchar *
+ov3_subscriber_set_config (Ov3Subscriber *self, const gchar *config)
+{
+  gchar *result;
+
+  result = setConfig ((gchar *) config);
+  if (!ov3_subscriber_result_ok (result)) {
+    GST_ERROR_OBJECT(self, "Could not set configuration: %s", result);
+    g_free (result);
+    return NULL;
+  }
+
+  return result;
+}
+
 static void
 ov3_subscriber_disconnect (Ov3Subscriber *self)
 {
   gchar *result;
+  GSList *item;
 
   if (self->priv->keyFrameProbeId > 0) {
     GstElement *element = gst_bin_get_by_name (GST_BIN(self), "video_source");
@@ -149,6 +398,31 @@ ov3_subscriber_disconnect (Ov3Subscriber *self)
     }
   }
 
+  for (item = self->priv->recordings; item != NULL; item = item->next) {
+    g_free (releaseRecording (item->data));
+  }
+  g_slist_free_full (self->priv->recordings, g_free);
+  self->priv->recordings = NULL;
+
+  if (self->priv->mixerId != NULL) {
+    result = destroyAudioMixer (self->priv->mixerId);
+    if (!ov3_subscriber_result_ok (result)) {
+      GST_ERROR_OBJECT(self, "Could not destroy audio mixer for room %s on service %s", self->priv->room, self->priv->url);
+    }
+    g_free (result);
+    g_free (self->priv->mixerId);
+    self->priv->mixerId = NULL;
+  }
+  if (self->priv->compositeId != NULL) {
+    result = destroyVideoComposite (self->priv->compositeId);
+    if (!ov3_subscriber_result_ok (result)) {
+      GST_ERROR_OBJECT(self, "Could not destroy video composite for room %s on service %s", self->priv->room, self->priv->url);
+    }
+    g_free (result);
+    g_free (self->priv->compositeId);
+    self->priv->compositeId = NULL;
+  }
+
   if (self->priv->subscriberId != NULL) {
     result = unsubscribeParticipant(self->priv->subscriberId);
     // If results begins with ERROR then no unsunscription could be made
@@ -158,16 +432,21 @@ ov3_subscriber_disconnect (Ov3Subscriber *self)
     }
 
     self->priv->subscriberId = NULL;
+  }
+
+  // Mixed subscriptions have no subscriber but are connected to the room as well
+  if (self->priv->egressId != NULL) {
     self->priv->connected = FALSE;
 
     result = disconnectFromRoom(self->priv->egressId);
+    g_free (self->priv->egressId);
+    self->priv->egressId = NULL;
     if ((result == NULL) ||(strlen(result) == 0) || (strncmp(result, "ERROR", 5) == 0)) {
       GST_INFO_OBJECT(self, "Not disconnecting from room %s on service %s", self->priv->room, self->priv->url);
       return;
     }
     GST_INFO_OBJECT(self, "Disconnected subscribe from room %s on service %s", self->priv->room, self->priv->url);
-}
-
+  }
 }
 
 
@@ -226,6 +505,22 @@ ov3_subscriber_finalize (GObject *object)
   if (self->priv->subscriberId != NULL) {
     g_free(self->priv->subscriberId);
   }
+  if (self->priv->options != NULL) {
+    g_free(self->priv->options);
+  }
+  if (self->priv->mixerOptions != NULL) {
+    g_free(self->priv->mixerOptions);
+  }
+  if (self->priv->compositeOptions != NULL) {
+    g_free(self->priv->compositeOptions);
+  }
+  if (self->priv->mixerId != NULL) {
+    g_free(self->priv->mixerId);
+  }
+  if (self->priv->compositeId != NULL) {
+    g_free(self->priv->compositeId);
+  }
+  g_slist_free_full (self->priv->recordings, g_free);
 }
 
 
@@ -265,6 +560,32 @@ ov3_subscriber_set_property (GObject * object, guint property_id,
       self->priv->screenshare = g_value_get_boolean (value);
       break;
     }
+    case PROP_OV3_OPTIONS:{
+      g_free (self->priv->options);
+      self->priv->options = g_value_dup_string (value);
+      break;
+    }
+    case PROP_OV3_ALIGNED:{
+      self->priv->aligned = g_value_get_boolean (value);
+      if (self->priv->connected) {
+        ov3_subscriber_align_room (self);
+      }
+      break;
+    }
+    case PROP_OV3_MIXED:{
+      self->priv->mixed = g_value_get_boolean (value);
+      break;
+    }
+    case PROP_OV3_MIXER_OPTIONS:{
+      g_free (self->priv->mixerOptions);
+      self->priv->mixerOptions = g_value_dup_string (value);
+      break;
+    }
+    case PROP_OV3_COMPOSITE_OPTIONS:{
+      g_free (self->priv->compositeOptions);
+      self->priv->compositeOptions = g_value_dup_string (value);
+      break;
+    }
     default:
       G_OBJECT_WARN_INVALID_PROPERTY_ID (object, property_id, pspec);
       break;
@@ -302,6 +623,26 @@ ov3_subscriber_get_property (GObject * object, guint property_id,
       g_value_set_boolean (value, self->priv->screenshare);
       break;
     }
+    case PROP_OV3_OPTIONS: {
+      g_value_set_string (value, self->priv->options);
+      break;
+    }
+    case PROP_OV3_ALIGNED: {
+      g_value_set_boolean (value, self->priv->aligned);
+      break;
+    }
+    case PROP_OV3_MIXED: {
+      g_value_set_boolean (value, self->priv->mixed);
+      break;
+    }
+    case PROP_OV3_MIXER_OPTIONS: {
+      g_value_set_string (value, self->priv->mixerOptions);
+      break;
+    }
+    case PROP_OV3_COMPOSITE_OPTIONS: {
+      g_value_set_string (value, self->priv->compositeOptions);
+      break;
+    }
     case PROP_OV3_CONNECTED: {
       g_value_set_boolean (value, self->priv->connected);
       break;
@@ -326,6 +667,15 @@ ov3_subscriber_class_init (Ov3SubscriberClass * klass)
   klass->ov3_connect = ov3_subscriber_connect ;
   klass->ov3_disconnect = ov3_subscriber_disconnect ;
   klass->ov3_request_keyframe = ov3_subscriber_request_keyframe ;
+  klass->ov3_set_mixer_gain = ov3_subscriber_set_mixer_gain ;
+  klass->ov3_set_composite_layout = ov3_subscriber_set_composite_layout ;
+  klass->ov3_start_recording = ov3_subscriber_start_recording ;
+  klass->ov3_split_recording = ov3_subscriber_split_recording ;
+  klass->ov3_stop_recording = ov3_subscriber_stop_recording ;
+  klass->ov3_get_recording_status = ov3_subscriber_get_recording_status ;
+  klass->ov3_release_recording = ov3_subscriber_release_recording ;
+  klass->ov3_dump_state = ov3_subscriber_dump_state ;
+  klass->ov3_set_config = ov3_subscriber_set_config ;
 
   gst_element_class_set_static_metadata (GST_ELEMENT_CLASS (klass),
       "OV3Subscriber", "Generic/KmsElement", "Kurento OpenVIdu 3 WebRtc subscriber",
@@ -361,6 +711,31 @@ ov3_subscriber_class_init (Ov3SubscriberClass * klass)
           "OpenVidu3 ScreenShare", "This endpoint must subscribe to screen share tracks",
           FALSE,
 		  G_PARAM_READWRITE | G_PARAM_STATIC_STRINGS));      
+  g_object_class_install_property (gobject_class, PROP_OV3_OPTIONS,
+      g_param_spec_string ("ov3-options",
+          "OpenVidu3 subscribe options", "JSON options for the subscription, such as the jitterbuffer settings",
+          "",
+		  G_PARAM_READWRITE | G_PARAM_STATIC_STRINGS));      
+  g_object_class_install_property (gobject_class, PROP_OV3_ALIGNED,
+      g_param_spec_boolean ("ov3-aligned",
+          "OpenVidu3 room alignment", "Subscriptions in the room share a common timeline",
+          FALSE,
+		  G_PARAM_READWRITE | G_PARAM_STATIC_STRINGS));      
+  g_object_class_install_property (gobject_class, PROP_OV3_MIXED,
+      g_param_spec_boolean ("ov3-mixed",
+          "OpenVidu3 mixed subscription", "This endpoint must subscribe to the audio mix and video composite of the room",
+          FALSE,
+		  G_PARAM_READWRITE | G_PARAM_STATIC_STRINGS));      
+  g_object_class_install_property (gobject_class, PROP_OV3_MIXER_OPTIONS,
+      g_param_spec_string ("ov3-mixer-options",
+          "OpenVidu3 mixer options", "JSON options for the audio mixer of a mixed subscription",
+          "",
+		  G_PARAM_READWRITE | G_PARAM_STATIC_STRINGS));      
+  g_object_class_install_property (gobject_class, PROP_OV3_COMPOSITE_OPTIONS,
+      g_param_spec_string ("ov3-composite-options",
+          "OpenVidu3 composite options", "JSON options for the video composite of a mixed subscription",
+          "",
+		  G_PARAM_READWRITE | G_PARAM_STATIC_STRINGS));      
   g_object_class_install_property (gobject_class, PROP_OV3_CONNECTED,
       g_param_spec_boolean ("ov3-connected",
           "OpenVidu3 Subscriber connected", "True if the endpoint is currently connected and subscribing tracks",
@@ -386,6 +761,60 @@ ov3_subscriber_class_init (Ov3SubscriberClass * klass)
       G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
       G_STRUCT_OFFSET (Ov3SubscriberClass, ov3_request_keyframe), NULL, NULL,
       NULL, G_TYPE_NONE, 0, G_TYPE_NONE);
+  obj_signals[SIGNAL_SET_MIXER_GAIN] =
+      g_signal_new ("ov3-set-mixer-gain",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3SubscriberClass, ov3_set_mixer_gain), NULL, NULL,
+      NULL, G_TYPE_BOOLEAN, 2, G_TYPE_STRING, G_TYPE_DOUBLE);
+  obj_signals[SIGNAL_SET_COMPOSITE_LAYOUT] =
+      g_signal_new ("ov3-set-composite-layout",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3SubscriberClass, ov3_set_composite_layout), NULL, NULL,
+      NULL, G_TYPE_BOOLEAN, 1, G_TYPE_STRING);
+  obj_signals[SIGNAL_START_RECORDING] =
+      g_signal_new ("ov3-start-recording",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3SubscriberClass, ov3_start_recording), NULL, NULL,
+      NULL, G_TYPE_STRING, 1, G_TYPE_STRING);
+  obj_signals[SIGNAL_SPLIT_RECORDING] =
+      g_signal_new ("ov3-split-recording",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3SubscriberClass, ov3_split_recording), NULL, NULL,
+      NULL, G_TYPE_BOOLEAN, 1, G_TYPE_STRING);
+  obj_signals[SIGNAL_STOP_RECORDING] =
+      g_signal_new ("ov3-stop-recording",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3SubscriberClass, ov3_stop_recording), NULL, NULL,
+      NULL, G_TYPE_BOOLEAN, 1, G_TYPE_STRING);
+  obj_signals[SIGNAL_GET_RECORDING_STATUS] =
+      g_signal_new ("ov3-get-recording-status",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3SubscriberClass, ov3_get_recording_status), NULL, NULL,
+      NULL, G_TYPE_STRING, 1, G_TYPE_STRING);
+  obj_signals[SIGNAL_RELEASE_RECORDING] =
+      g_signal_new ("ov3-release-recording",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3SubscriberClass, ov3_release_recording), NULL, NULL,
+      NULL, G_TYPE_BOOLEAN, 1, G_TYPE_STRING);
+  obj_signals[SIGNAL_DUMP_STATE] =
+      g_signal_new ("ov3-dump-state",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3SubscriberClass, ov3_dump_state), NULL, NULL,
+      NULL, G_TYPE_STRING, 0, G_TYPE_NONE);
+  obj_signals[SIGNAL_SET_CONFIG] =
+      g_signal_new ("ov3-set-config",
+      G_TYPE_FROM_CLASS (klass),
+      G_SIGNAL_ACTION | G_SIGNAL_RUN_LAST,
+      G_STRUCT_OFFSET (Ov3SubscriberClass, ov3_set_config), NULL, NULL,
+      NULL, G_TYPE_STRING, 1, G_TYPE_STRING);
 
   g_type_class_add_private (klass, sizeof (Ov3SubscriberPrivate));
 
@@ -436,6 +865,14 @@ ov3_subscriber_init (Ov3Subscriber * self)
   self->priv->room = g_strdup ("");
   self->priv->participant = g_strdup ("");
   self->priv->screenshare = FALSE;
+  self->priv->options = g_strdup ("");
+  self->priv->aligned = FALSE;
+  self->priv->mixed = FALSE;
+  self->priv->mixerOptions = g_strdup ("");
+  self->priv->compositeOptions = g_strdup ("");
+  self->priv->mixerId = NULL;
+  self->priv->compositeId = NULL;
+  self->priv->recordings = NULL;
   self->priv->egressId = NULL;
   self->priv->subscriberId = NULL;
   self->priv->connected = FALSE;
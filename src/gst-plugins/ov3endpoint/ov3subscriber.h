@@ -65,6 +65,15 @@ struct _Ov3SubscriberClass
   void (*ov3_connect) (Ov3Subscriber *obj);
   void (*ov3_disconnect) (Ov3Subscriber *obj);
   void (*ov3_request_keyframe) (Ov3Subscriber *obj);
+  gboolean (*ov3_set_mixer_gain) (Ov3Subscriber *obj, const gchar *participant, gdouble gain);
+  gboolean (*ov3_set_composite_layout) (Ov3Subscriber *obj, const gchar *layout);
+  gchar * (*ov3_start_recording) (Ov3Subscriber *obj, const gchar *options);
+  gboolean (*ov3_split_recording) (Ov3Subscriber *obj, const gchar *recordingId);
+  gboolean (*ov3_stop_recording) (Ov3Subscriber *obj, const gchar *recordingId);
+  gchar * (*ov3_get_recording_status) (Ov3Subscriber *obj, const gchar *recordingId);
+  gboolean (*ov3_release_recording) (Ov3Subscriber *obj, const gchar *recordingId);
+  gchar * (*ov3_dump_state) (Ov3Subscriber *obj);
+  gchar * (*ov3_set_config) (Ov3Subscriber *obj, const gchar *config);
 };
 
 GType ov3_subscriber_get_type (void);
@@ -19,6 +19,7 @@
 #include "MediaPipelineImpl.hpp"
 #include <jsonrpc/JsonSerializer.hpp>
 #include <KurentoException.hpp>
+#include <SignalHandler.hpp>
 
 #include "OV3PublisherImpl.hpp"
 #include <OV3PublisherImplFactory.hpp>
@@ -43,11 +44,19 @@ OV3PublisherImpl::OV3PublisherImpl (const boost::property_tree::ptree &config,
                                             bool _screenShare)  : MediaElementImpl (config,
                                         std::dynamic_pointer_cast<MediaObjectImpl> (mediaPipeline), FACTORY_NAME),
                                         url (_url), secret (_secret), key (_key), room(_room), participantId(_participantId), 
-                                        participantName(_participantName), screenShare(_screenShare), isConnected(false)
+                                        participantName(_participantName), screenShare(_screenShare), isConnected(false),
+                                        publishAudio(false), publishVideo(false), handlerOnEvent(0)
 
 {
 }
 
+OV3PublisherImpl::~OV3PublisherImpl ()
+{
+  if (handlerOnEvent > 0) {
+    unregister_signal_handler (element, handlerOnEvent);
+  }
+}
+
 MediaObjectImpl *
 OV3PublisherImplFactory::createObject (const boost::property_tree::ptree &conf, 
                                             std::shared_ptr<MediaPipeline> mediaPipeline, 
@@ -97,6 +106,24 @@ void OV3PublisherImpl::postConstructor ()
 {
   MediaElementImpl::postConstructor ();
 
+  handlerOnEvent = register_signal_handler (G_OBJECT (element),
+                   "ov3-event",
+                   std::function <void (GstElement *, gchar *, gchar *) > (std::bind (&OV3PublisherImpl::onEvent, this,
+                       std::placeholders::_2, std::placeholders::_3) ),
+                   std::dynamic_pointer_cast<OV3PublisherImpl>
+                   (shared_from_this() ) );
+}
+
+void OV3PublisherImpl::onEvent (gchar *name, gchar *data)
+{
+  try {
+    OV3Event event (shared_from_this (), OV3Event::getName (), name, data);
+    sigcSignalEmit (signalOV3Event, event);
+  } catch (const std::bad_weak_ptr &e) {
+    // shared_from_this()
+    GST_ERROR ("BUG creating %s: %s", OV3Event::getName ().c_str (),
+        e.what ());
+  }
 }
 
 void OV3PublisherImpl::release ()
@@ -106,7 +133,7 @@ void OV3PublisherImpl::release ()
   MediaElementImpl::release ();
 }
 
-bool OV3PublisherImpl::publishParticipant (bool pubAudio, bool pubVideo)
+void OV3PublisherImpl::setConnectionProperties ()
 {
   if (this->isConnected) {
         throw KurentoException (SDP_END_POINT_ALREADY_NEGOTIATED,
@@ -119,8 +146,14 @@ bool OV3PublisherImpl::publishParticipant (bool pubAudio, bool pubVideo)
                          "ov3-room", room.c_str(), 
                          "ov3-participant-name", participantName.c_str(), 
                          "ov3-participant-id", participantId.c_str(), 
-                         "ov3-screenshare", this->screenShare, 
-                         "ov3-publishAudio", pubAudio,
+                         "ov3-screenshare", this->screenShare, NULL); 
+}
+
+bool OV3PublisherImpl::publishParticipant (bool pubAudio, bool pubVideo)
+{
+  setConnectionProperties ();
+
+  g_object_set (element, "ov3-publishAudio", pubAudio,
                          "ov3-publishVideo", pubVideo, NULL); 
 
   g_signal_emit_by_name (element, "ov3-connect");
@@ -130,6 +163,101 @@ bool OV3PublisherImpl::publishParticipant (bool pubAudio, bool pubVideo)
   return isConnected;
 }
 
+bool OV3PublisherImpl::publishStream (const std::string &source, const std::string &options)
+{
+  setConnectionProperties ();
+
+  g_object_set (element, "ov3-ingest-source", source.c_str(),
+                         "ov3-ingest-options", options.c_str(),
+                         "ov3-ingest-file", FALSE, NULL); 
+
+  g_signal_emit_by_name (element, "ov3-connect");
+
+  g_object_get (element, "ov3-connected", &isConnected, NULL);
+
+  return isConnected;
+}
+
+bool OV3PublisherImpl::publishFile (const std::string &path, bool loop)
+{
+  setConnectionProperties ();
+
+  g_object_set (element, "ov3-ingest-source", path.c_str(),
+                         "ov3-ingest-file", TRUE,
+                         "ov3-ingest-loop", loop, NULL); 
+
+  g_signal_emit_by_name (element, "ov3-connect");
+
+  g_object_get (element, "ov3-connected", &isConnected, NULL);
+
+  return isConnected;
+}
+
+bool 
+OV3PublisherImpl::setMuted (const std::string &kind, bool muted)
+{
+  gboolean ret = FALSE;
+
+  g_signal_emit_by_name (element, "ov3-set-muted", kind.c_str(), muted, &ret);
+
+  return ret;
+}
+
+bool 
+OV3PublisherImpl::pauseFile (bool paused)
+{
+  gboolean ret = FALSE;
+
+  g_signal_emit_by_name (element, "ov3-pause-file", paused, &ret);
+
+  return ret;
+}
+
+bool 
+OV3PublisherImpl::seekFile (int64_t position)
+{
+  gboolean ret = FALSE;
+
+  g_signal_emit_by_name (element, "ov3-seek-file", (gint64) position, &ret);
+
+  return ret;
+}
+
+std::string 
+OV3PublisherImpl::dumpState ()
+{
+  gchar *result = NULL;
+
+  g_signal_emit_by_name (element, "ov3-dump-state", &result);
+
+  return takeResult (result, "dump state");
+}
+
+std::string 
+OV3PublisherImpl::setConfig (const std::string &config)
+{
+  gchar *result = NULL;
+
+  g_signal_emit_by_name (element, "ov3-set-config", config.c_str(), &result);
+
+  return takeResult (result, "set configuration");
+}
+
+// The element logs why an operation failed and returns NULL
+std::string 
+OV3PublisherImpl::takeResult (gchar *result, const std::string &operation)
+{
+  if (result == NULL) {
+        throw KurentoException (MEDIA_OBJECT_ILLEGAL_PARAM_ERROR,
+                            "Could not " + operation);
+  }
+
+  std::string value (result);
+  g_free (result);
+
+  return value;
+}
+
 
 OV3PublisherImpl::StaticConstructor OV3PublisherImpl::staticConstructor;
 
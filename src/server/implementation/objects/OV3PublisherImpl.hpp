@@ -20,6 +20,7 @@
 
 #include "MediaElementImpl.hpp"
 #include "OV3Publisher.hpp"
+#include "OV3Event.hpp"
 #include <EventHandler.hpp>
 #include <boost/property_tree/ptree.hpp>
 
@@ -55,6 +56,7 @@ class OV3PublisherImpl : public MediaElementImpl, public virtual OV3Publisher
   bool isConnected;
   bool publishAudio;
   bool publishVideo;
+  gulong handlerOnEvent;
 
 public:
 
@@ -68,11 +70,22 @@ public:
                                             const std::string &participantId,
                                             bool screenShare);
 
-  virtual ~OV3PublisherImpl () {};
+  virtual ~OV3PublisherImpl ();
 
   virtual bool publishParticipant () { return publishParticipant(true, true); };
   virtual bool publishParticipant (bool publishAudio) { return publishParticipant(publishAudio, true); };
   virtual bool publishParticipant (bool publishAudio, bool publishVideo);
+  virtual bool publishStream (const std::string &source) { return publishStream(source, ""); };
+  virtual bool publishStream (const std::string &source, const std::string &options);
+  virtual bool publishFile (const std::string &path) { return publishFile(path, false); };
+  virtual bool publishFile (const std::string &path, bool loop);
+
+  virtual bool setMuted (const std::string &kind, bool muted);
+  virtual bool pauseFile (bool paused);
+  virtual bool seekFile (int64_t position);
+
+  virtual std::string dumpState ();
+  virtual std::string setConfig (const std::string &config);
 
 
 
@@ -85,6 +98,7 @@ public:
 
   virtual void release () override;
 
+  sigc::signal<void, OV3Event> signalOV3Event;
 
   /* Next methods are automatically implemented by code generator */
   using MediaElementImpl::connect;
@@ -101,6 +115,10 @@ protected:
   
 private:
 
+  std::string takeResult (gchar *result, const std::string &operation);
+  void setConnectionProperties ();
+  void onEvent (gchar *name, gchar *data);
+
   class StaticConstructor
   {
   public:
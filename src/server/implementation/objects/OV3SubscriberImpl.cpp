@@ -38,7 +38,7 @@ OV3SubscriberImpl::OV3SubscriberImpl (const boost::property_tree::ptree &config,
                                             const std::string &_secret, 
                                             const std::string &_key)  : MediaElementImpl (config,
                                         std::dynamic_pointer_cast<MediaObjectImpl> (mediaPipeline), FACTORY_NAME),
-                                        url (_url), secret (_secret), key (_key), isConnected (false)
+                                        url (_url), secret (_secret), key (_key), screenShare (false), roomAligned (false), isConnected (false)
 
 {
 }
@@ -77,6 +77,13 @@ OV3SubscriberImpl::getScreenShare ()
   return screenShare;
 }
 
+void 
+OV3SubscriberImpl::setRoomAligned (bool roomAligned)
+{
+  this->roomAligned = roomAligned;
+  g_object_set (element, "ov3-aligned", roomAligned, NULL);
+}
+
 
 void OV3SubscriberImpl::postConstructor ()
 {
@@ -91,7 +98,7 @@ void OV3SubscriberImpl::release ()
   MediaElementImpl::release ();
 }
 
-bool OV3SubscriberImpl::subscribeParticipant (const std::string &room, const std::string &participantId, bool screenShare)
+bool OV3SubscriberImpl::subscribeParticipant (const std::string &room, const std::string &participantId, bool screenShare, const std::string &options)
 {
   if (this->isConnected) {
         throw KurentoException (SDP_END_POINT_ALREADY_NEGOTIATED,
@@ -106,7 +113,31 @@ bool OV3SubscriberImpl::subscribeParticipant (const std::string &room, const std
                          "ov3-key", key.c_str(), 
                          "ov3-room", room.c_str(), 
                          "ov3-participant", participantId.c_str(), 
-                         "ov3-screenshare", screenShare, NULL); 
+                         "ov3-screenshare", screenShare, 
+                         "ov3-options", options.c_str(), NULL); 
+
+  g_signal_emit_by_name (element, "ov3-connect");
+
+  g_object_get (element, "ov3-connected", &isConnected, NULL);
+
+  return isConnected;
+}
+
+bool OV3SubscriberImpl::subscribeRoom (const std::string &room, const std::string &mixerOptions, const std::string &compositeOptions)
+{
+  if (this->isConnected) {
+        throw KurentoException (SDP_END_POINT_ALREADY_NEGOTIATED,
+                            "Endpoint already negotiated");
+  }
+  this->room = room;
+
+  g_object_set (element, "ov3-url", url.c_str(), 
+                         "ov3-secret", secret.c_str(),
+                         "ov3-key", key.c_str(), 
+                         "ov3-room", room.c_str(), 
+                         "ov3-mixed", TRUE, 
+                         "ov3-mixer-options", mixerOptions.c_str(), 
+                         "ov3-composite-options", compositeOptions.c_str(), NULL); 
 
   g_signal_emit_by_name (element, "ov3-connect");
 
@@ -123,6 +154,111 @@ OV3SubscriberImpl::requestKeyFrame ()
   }
 }
 
+bool 
+OV3SubscriberImpl::setMixerGain (const std::string &participantId, double gain)
+{
+  gboolean ret = FALSE;
+
+  g_signal_emit_by_name (element, "ov3-set-mixer-gain", participantId.c_str(), gain, &ret);
+
+  return ret;
+}
+
+bool 
+OV3SubscriberImpl::setCompositeLayout (const std::string &layout)
+{
+  gboolean ret = FALSE;
+
+  g_signal_emit_by_name (element, "ov3-set-composite-layout", layout.c_str(), &ret);
+
+  return ret;
+}
+
+std::string 
+OV3SubscriberImpl::startRecording (const std::string &options)
+{
+  gchar *result = NULL;
+
+  g_signal_emit_by_name (element, "ov3-start-recording", options.c_str(), &result);
+
+  return takeResult (result, "start recording");
+}
+
+bool 
+OV3SubscriberImpl::splitRecording (const std::string &recordingId)
+{
+  gboolean ret = FALSE;
+
+  g_signal_emit_by_name (element, "ov3-split-recording", recordingId.c_str(), &ret);
+
+  return ret;
+}
+
+bool 
+OV3SubscriberImpl::stopRecording (const std::string &recordingId)
+{
+  gboolean ret = FALSE;
+
+  g_signal_emit_by_name (element, "ov3-stop-recording", recordingId.c_str(), &ret);
+
+  return ret;
+}
+
+std::string 
+OV3SubscriberImpl::getRecordingStatus (const std::string &recordingId)
+{
+  gchar *result = NULL;
+
+  g_signal_emit_by_name (element, "ov3-get-recording-status", recordingId.c_str(), &result);
+
+  return takeResult (result, "get status of recording " + recordingId);
+}
+
+bool 
+OV3SubscriberImpl::releaseRecording (const std::string &recordingId)
+{
+  gboolean ret = FALSE;
+
+  g_signal_emit_by_name (element, "ov3-release-recording", recordingId.c_str(), &ret);
+
+  return ret;
+}
+
+std::string 
+OV3SubscriberImpl::dumpState ()
+{
+  gchar *result = NULL;
+
+  g_signal_emit_by_name (element, "ov3-dump-state", &result);
+
+  return takeResult (result, "dump state");
+}
+
+std::string 
+OV3SubscriberImpl::setConfig (const std::string &config)
+{
+  gchar *result = NULL;
+
+  g_signal_emit_by_name (element, "ov3-set-config", config.c_str(), &result);
+
+  return takeResult (result, "set configuration");
+}
+
+// The element logs why an operation failed and returns NULL
+std::string 
+OV3SubscriberImpl::takeResult (gchar *result, const std::string &operation)
+{
+  if (result == NULL) {
+        throw KurentoException (MEDIA_OBJECT_ILLEGAL_PARAM_ERROR,
+                            "Could not " + operation);
+  }
+
+  std::string value (result);
+  g_free (result);
+
+  return value;
+}
+
 
 OV3SubscriberImpl::StaticConstructor OV3SubscriberImpl::staticConstructor;
 
@@ -51,6 +51,7 @@ class OV3SubscriberImpl : public MediaElementImpl, public virtual OV3Subscriber
   std::string room;
   std::string participantId;
   bool screenShare;
+  bool roomAligned;
   bool isConnected;
 
 public:
@@ -63,13 +64,32 @@ public:
 
   virtual ~OV3SubscriberImpl () {};
 
-  virtual bool subscribeParticipant (const std::string &room, const std::string &participantId, bool screenShare);
+  virtual bool subscribeParticipant (const std::string &room, const std::string &participantId, bool screenShare) { return subscribeParticipant(room, participantId, screenShare, ""); };
+  virtual bool subscribeParticipant (const std::string &room, const std::string &participantId, bool screenShare, const std::string &options);
+  virtual bool subscribeRoom (const std::string &room) { return subscribeRoom(room, "", ""); };
+  virtual bool subscribeRoom (const std::string &room, const std::string &mixerOptions) { return subscribeRoom(room, mixerOptions, ""); };
+  virtual bool subscribeRoom (const std::string &room, const std::string &mixerOptions, const std::string &compositeOptions);
   virtual void requestKeyFrame ();
 
+  virtual bool setMixerGain (const std::string &participantId, double gain);
+  virtual bool setCompositeLayout (const std::string &layout);
+
+  virtual std::string startRecording () { return startRecording(""); };
+  virtual std::string startRecording (const std::string &options);
+  virtual bool splitRecording (const std::string &recordingId);
+  virtual bool stopRecording (const std::string &recordingId);
+  virtual std::string getRecordingStatus (const std::string &recordingId);
+  virtual bool releaseRecording (const std::string &recordingId);
+
+  virtual std::string dumpState ();
+  virtual std::string setConfig (const std::string &config);
+
   virtual std::string getUrl ();
   virtual std::string getRoom ();
   virtual std::string getParticipantId ();
   virtual bool getScreenShare ();
+  virtual bool getRoomAligned () { return roomAligned; };
+  virtual void setRoomAligned (bool roomAligned);
   virtual bool getIsConnected () { return isConnected; };
 
   virtual void release () override;
@@ -90,6 +110,8 @@ protected:
   
 private:
 
+  std::string takeResult (gchar *result, const std::string &operation);
+
   class StaticConstructor
   {
   public: